@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/getlantern/lampshade"
+)
+
+// sizedBufferSource is implemented by BufferSource implementations that can
+// hand back a buffer sized to fit n bytes rather than always returning
+// their default (often oversized) buffer. proxy.copy doesn't currently have
+// a size hint to offer - CONNECT tunnels carry no declared length - so
+// nothing in this package calls GetSized today; it's here for callers that
+// do have one, such as a future Transport or a caller of NewTieredBufferSource
+// directly.
+type sizedBufferSource interface {
+	GetSized(n int) []byte
+}
+
+// pooledBufferSource is a BufferSource backed by a single sync.Pool of
+// fixed-size buffers.
+type pooledBufferSource struct {
+	size int
+	pool sync.Pool
+}
+
+// NewPooledBufferSource returns a BufferSource that hands out buffers of
+// size bytes from a sync.Pool instead of allocating a fresh buffer on every
+// Get(), as defaultBufferSource does.
+func NewPooledBufferSource(size int) BufferSource {
+	bs := &pooledBufferSource{size: size}
+	bs.pool.New = func() interface{} {
+		return make([]byte, bs.size)
+	}
+	return bs
+}
+
+func (bs *pooledBufferSource) Get() []byte {
+	return bs.pool.Get().([]byte)
+}
+
+func (bs *pooledBufferSource) Put(buf []byte) {
+	bs.pool.Put(buf) //nolint:staticcheck // intentionally pooling a slice header
+}
+
+// tieredBufferSource is a BufferSource that maintains a separate sync.Pool
+// per size class and, via GetSized, picks the smallest tier that's still
+// large enough to satisfy the request.
+type tieredBufferSource struct {
+	tiers []*pooledBufferSource // sorted ascending by size
+}
+
+// NewTieredBufferSource returns a BufferSource backed by one sync.Pool per
+// entry in sizes. Get() returns a buffer from the largest tier (so it can
+// serve as a drop-in default), while GetSized(n) picks the smallest tier
+// whose size is >= n, falling back to the largest tier if n exceeds all of
+// them. sizes need not be powers of two, but conventionally are (e.g.
+// 4<<10, 16<<10, 64<<10) so that a handful of tiers cover a wide range of
+// request sizes without much waste. If sizes is empty, a single tier sized
+// to lampshade.MaxDataLen is used, matching defaultBufferSource's size.
+func NewTieredBufferSource(sizes ...int) BufferSource {
+	if len(sizes) == 0 {
+		sizes = []int{lampshade.MaxDataLen}
+	}
+	sorted := append([]int(nil), sizes...)
+	sort.Ints(sorted)
+	tiers := make([]*pooledBufferSource, len(sorted))
+	for i, size := range sorted {
+		tiers[i] = NewPooledBufferSource(size).(*pooledBufferSource)
+	}
+	return &tieredBufferSource{tiers: tiers}
+}
+
+func (bs *tieredBufferSource) Get() []byte {
+	return bs.tiers[len(bs.tiers)-1].Get()
+}
+
+func (bs *tieredBufferSource) Put(buf []byte) {
+	n := len(buf)
+	for _, tier := range bs.tiers {
+		if tier.size == n {
+			tier.Put(buf)
+			return
+		}
+	}
+	// Buffer doesn't match any known tier size; nothing to do but let it be
+	// garbage collected.
+}
+
+func (bs *tieredBufferSource) GetSized(n int) []byte {
+	for _, tier := range bs.tiers {
+		if tier.size >= n {
+			return tier.Get()
+		}
+	}
+	return bs.tiers[len(bs.tiers)-1].Get()
+}
@@ -0,0 +1,32 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/getlantern/lampshade"
+)
+
+func TestTieredBufferSourceEmptySizes(t *testing.T) {
+	bs := NewTieredBufferSource()
+	buf := bs.Get()
+	if len(buf) != lampshade.MaxDataLen {
+		t.Fatalf("expected fallback tier of size %d, got %d", lampshade.MaxDataLen, len(buf))
+	}
+}
+
+func TestTieredBufferSourceGetSized(t *testing.T) {
+	bs := NewTieredBufferSource(4<<10, 16<<10, 64<<10)
+
+	small := bs.GetSized(1024)
+	if len(small) != 4<<10 {
+		t.Fatalf("expected smallest tier >= 1024 (4KB), got %d", len(small))
+	}
+
+	huge := bs.GetSized(1 << 20)
+	if len(huge) != 64<<10 {
+		t.Fatalf("expected requests larger than every tier to fall back to the largest tier, got %d", len(huge))
+	}
+
+	bs.Put(small)
+	bs.Put(huge)
+}
@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"context"
+	"net"
+
+	"github.com/getlantern/proxy/filters"
+)
+
+type ctxKey string
+
+const (
+	ctxKeyUpstream     = ctxKey("upstream")
+	ctxKeyUpstreamAddr = ctxKey("upstreamAddr")
+	ctxKeyUpstreamHost = ctxKey("upstreamHost")
+)
+
+// proxyContext is the concrete implementation of filters.Context used
+// internally by proxy.
+type proxyContext struct {
+	context.Context
+	downstream    net.Conn
+	requestNumber int
+}
+
+func (ctx *proxyContext) DownstreamConn() net.Conn {
+	return ctx.downstream
+}
+
+func (ctx *proxyContext) RequestNumber() int {
+	return ctx.requestNumber
+}
+
+// contextWithValue returns a copy of ctx that carries the given key/value
+// pair while continuing to satisfy filters.Context.
+func contextWithValue(ctx filters.Context, key, val interface{}) filters.Context {
+	return &proxyContext{
+		Context:       context.WithValue(ctx, key, val),
+		downstream:    ctx.DownstreamConn(),
+		requestNumber: ctx.RequestNumber(),
+	}
+}
+
+// Dial dials the given network address using the configured DialFunc,
+// defaulting to net.Dial if none was specified.
+func (proxy *proxy) Dial(isCONNECT bool, network, addr string) (net.Conn, error) {
+	if proxy.Opts.Dial != nil {
+		return proxy.Opts.Dial(isCONNECT, network, addr)
+	}
+	return net.Dial(network, addr)
+}
+
+// newProxyContext builds the initial filters.Context for a freshly
+// accepted downstream connection, wired up with proxy.Tracer so that
+// filters.Chain.apply can start a child span per filter.
+func (proxy *proxy) newProxyContext(downstream net.Conn, requestNumber int) filters.Context {
+	ctx := &proxyContext{
+		Context:       context.Background(),
+		downstream:    downstream,
+		requestNumber: requestNumber,
+	}
+	return filters.ContextWithTracer(ctx, proxy.Tracer)
+}
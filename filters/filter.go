@@ -2,6 +2,7 @@ package filters
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
@@ -109,5 +110,17 @@ func (c Chain) apply(ctx Context, req *http.Request, next Next, idx int) (*http.
 			return c.apply(ctx, req, next, idx+1)
 		}
 	}
-	return c[idx].Apply(ctx, req, _next)
+
+	span := TracerFromContext(ctx).StartSpan(ctx, fmt.Sprintf("%T", c[idx]))
+	span.SetTag("requestNumber", ctx.RequestNumber())
+	if downstream := ctx.DownstreamConn(); downstream != nil {
+		span.SetTag("downstreamAddr", downstream.RemoteAddr().String())
+	}
+	defer span.Finish()
+
+	resp, nextCtx, err := c[idx].Apply(ContextWithSpan(ctx, span), req, _next)
+	if resp != nil {
+		span.SetTag("statusCode", resp.StatusCode)
+	}
+	return resp, nextCtx, err
 }
@@ -0,0 +1,53 @@
+package filters
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// otelTracer adapts an OpenTelemetry TracerProvider to Tracer.
+type otelTracer struct {
+	tracer trace.Tracer
+}
+
+// NewOpenTelemetryTracer adapts tp to Tracer, so that filter spans show up
+// as regular OpenTelemetry spans wherever tp is configured to export them.
+func NewOpenTelemetryTracer(tp trace.TracerProvider) Tracer {
+	return &otelTracer{tracer: tp.Tracer("github.com/getlantern/proxy/filters")}
+}
+
+func (t *otelTracer) StartSpan(ctx Context, name string) Span {
+	otelCtx := context.Context(ctx)
+	if parent, ok := SpanFromContext(ctx).(*otelSpan); ok {
+		// Chain.apply attaches the previous filter's Span to ctx via
+		// ContextWithSpan, but that's our own Span wrapper, not something
+		// OTel's own context propagation knows about. Re-derive an OTel
+		// parent from it so Start actually nests the new span under it.
+		otelCtx = trace.ContextWithSpan(otelCtx, parent.span)
+	}
+	_, span := t.tracer.Start(otelCtx, name)
+	return &otelSpan{span: span}
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s *otelSpan) SetTag(key string, value interface{}) {
+	s.span.SetAttributes(attribute.String(key, fmt.Sprint(value)))
+}
+
+func (s *otelSpan) LogFields(fields map[string]interface{}) {
+	attrs := make([]attribute.KeyValue, 0, len(fields))
+	for k, v := range fields {
+		attrs = append(attrs, attribute.String(k, fmt.Sprint(v)))
+	}
+	s.span.AddEvent("", trace.WithAttributes(attrs...))
+}
+
+func (s *otelSpan) Finish() {
+	s.span.End()
+}
@@ -0,0 +1,48 @@
+package filters
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestOpenTelemetryTracerNestsSpansPerFilter(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	tracer := NewOpenTelemetryTracer(tp)
+
+	ctx := ContextWithTracer(&fakeContext{Context: context.Background()}, tracer)
+
+	first := FilterFunc(func(ctx Context, req *http.Request, next Next) (*http.Response, Context, error) {
+		return next(ctx, req)
+	})
+	second := FilterFunc(func(ctx Context, req *http.Request, next Next) (*http.Response, Context, error) {
+		return next(ctx, req)
+	})
+	chain := Join(first, second)
+
+	req, err := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = chain.Apply(ctx, req, func(ctx Context, req *http.Request) (*http.Response, Context, error) {
+		return &http.Response{StatusCode: http.StatusOK}, ctx, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	// Spans are exported in Finish order, so the second filter's (inner)
+	// span finishes first.
+	inner, outer := spans[0], spans[1]
+	if inner.Parent.SpanID() != outer.SpanContext.SpanID() {
+		t.Fatal("expected the second filter's span to be a child of the first filter's span")
+	}
+}
@@ -0,0 +1,104 @@
+package filters
+
+import "context"
+
+// Span represents a single unit of traced work carried out while
+// processing a request, modeled loosely on OpenTracing/OpenTelemetry spans
+// so that adapters for either are straightforward to write.
+type Span interface {
+	// SetTag attaches a key/value tag to the span.
+	SetTag(key string, value interface{})
+
+	// LogFields attaches a timestamped event with the given key/value
+	// fields to the span.
+	LogFields(fields map[string]interface{})
+
+	// Finish marks the span as complete.
+	Finish()
+}
+
+// Tracer creates new spans. Implementations must be safe for concurrent
+// use.
+type Tracer interface {
+	// StartSpan starts a new span named name as a child of whatever Span is
+	// already attached to ctx, if any.
+	StartSpan(ctx Context, name string) Span
+}
+
+// DefaultTracer is the Tracer used when none has been attached to a
+// Context via ContextWithTracer. Its spans discard everything, so filters
+// can call SpanFromContext/StartSpan unconditionally without a nil check.
+var DefaultTracer Tracer = noopTracer{}
+
+// NoopSpan returns a Span that discards everything, for callers that need
+// to pass a Span but don't have a traced Context on hand.
+func NoopSpan() Span { return noopSpan{} }
+
+type noopTracer struct{}
+
+func (noopTracer) StartSpan(ctx Context, name string) Span { return noopSpan{} }
+
+type noopSpan struct{}
+
+func (noopSpan) SetTag(key string, value interface{})    {}
+func (noopSpan) LogFields(fields map[string]interface{}) {}
+func (noopSpan) Finish()                                 {}
+
+type tracerKey struct{}
+type spanKey struct{}
+
+// ContextWithTracer returns a copy of ctx that carries tracer, so that
+// Chain.apply can start a child span for each filter from it. Wire this in
+// once when constructing the initial Context for a connection.
+func ContextWithTracer(ctx Context, tracer Tracer) Context {
+	return &tracerContext{Context: ctx, tracer: tracer}
+}
+
+type tracerContext struct {
+	Context
+	tracer Tracer
+}
+
+func (c *tracerContext) Value(key interface{}) interface{} {
+	if _, ok := key.(tracerKey); ok {
+		return c.tracer
+	}
+	return c.Context.Value(key)
+}
+
+// TracerFromContext returns the Tracer attached to ctx via
+// ContextWithTracer, or DefaultTracer if none was attached.
+func TracerFromContext(ctx context.Context) Tracer {
+	if tracer, ok := ctx.Value(tracerKey{}).(Tracer); ok {
+		return tracer
+	}
+	return DefaultTracer
+}
+
+// ContextWithSpan returns a copy of ctx that carries span, retrievable via
+// SpanFromContext.
+func ContextWithSpan(ctx Context, span Span) Context {
+	return &spanContext{Context: ctx, span: span}
+}
+
+type spanContext struct {
+	Context
+	span Span
+}
+
+func (c *spanContext) Value(key interface{}) interface{} {
+	if _, ok := key.(spanKey); ok {
+		return c.span
+	}
+	return c.Context.Value(key)
+}
+
+// SpanFromContext returns the Span attached to ctx via ContextWithSpan, or
+// a no-op Span if none was attached, so callers can use the result
+// unconditionally.
+func SpanFromContext(ctx context.Context) Span {
+	if span, ok := ctx.Value(spanKey{}).(Span); ok {
+		return span
+	}
+	return noopSpan{}
+}
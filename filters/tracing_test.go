@@ -0,0 +1,81 @@
+package filters
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+)
+
+type fakeContext struct {
+	context.Context
+}
+
+func (c *fakeContext) DownstreamConn() net.Conn { return nil }
+func (c *fakeContext) RequestNumber() int       { return 1 }
+
+type recordingSpan struct {
+	name   string
+	parent *recordingSpan
+	tags   map[string]interface{}
+}
+
+func (s *recordingSpan) SetTag(key string, value interface{}) {
+	if s.tags == nil {
+		s.tags = map[string]interface{}{}
+	}
+	s.tags[key] = value
+}
+
+func (s *recordingSpan) LogFields(fields map[string]interface{}) {}
+func (s *recordingSpan) Finish()                                 {}
+
+type recordingTracer struct {
+	spans []*recordingSpan
+}
+
+func (t *recordingTracer) StartSpan(ctx Context, name string) Span {
+	parent, _ := SpanFromContext(ctx).(*recordingSpan)
+	span := &recordingSpan{name: name, parent: parent}
+	t.spans = append(t.spans, span)
+	return span
+}
+
+func TestChainStartsAChildSpanPerFilter(t *testing.T) {
+	tracer := &recordingTracer{}
+	ctx := ContextWithTracer(&fakeContext{Context: context.Background()}, tracer)
+
+	var sawSpan *recordingSpan
+	first := FilterFunc(func(ctx Context, req *http.Request, next Next) (*http.Response, Context, error) {
+		return next(ctx, req)
+	})
+	second := FilterFunc(func(ctx Context, req *http.Request, next Next) (*http.Response, Context, error) {
+		sawSpan, _ = SpanFromContext(ctx).(*recordingSpan)
+		return next(ctx, req)
+	})
+
+	chain := Join(first, second)
+	req, err := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = chain.Apply(ctx, req, func(ctx Context, req *http.Request) (*http.Response, Context, error) {
+		return &http.Response{StatusCode: http.StatusOK}, ctx, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tracer.spans) != 2 {
+		t.Fatalf("expected 2 spans (one per filter), got %d", len(tracer.spans))
+	}
+	if sawSpan != tracer.spans[1] {
+		t.Fatal("expected the second filter to see its own span via SpanFromContext")
+	}
+	if tracer.spans[1].parent != tracer.spans[0] {
+		t.Fatal("expected the second filter's span to be parented on the first filter's span")
+	}
+	if tracer.spans[0].tags["statusCode"] != http.StatusOK {
+		t.Fatalf("expected statusCode tag to be set on the finished span, got %v", tracer.spans[0].tags["statusCode"])
+	}
+}
@@ -0,0 +1,233 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HealthChecksConfig configures active and passive health checking of the
+// hosts in an Opts.Pool. Attach it via Opts.HealthChecks.
+type HealthChecksConfig struct {
+	// Active, if non-nil, enables periodic active health checks against
+	// each pool host.
+	Active *ActiveHealthCheckConfig
+
+	// Passive, if non-nil, enables passive health checking based on
+	// observed traffic in dialAndCopy/copy.
+	Passive *PassiveHealthCheckConfig
+}
+
+// ActiveHealthCheckConfig configures periodic active health checks.
+type ActiveHealthCheckConfig struct {
+	// Interval is how often to check each host. Defaults to 30 seconds.
+	Interval time.Duration
+
+	// Timeout bounds each individual check. Defaults to 5 seconds.
+	Timeout time.Duration
+
+	// Path is the HTTP path to GET on each host. If empty, checks fall
+	// back to a raw TCP dial, which is appropriate for CONNECT-only
+	// upstreams that don't speak plain HTTP.
+	Path string
+
+	// ExpectStatusMin and ExpectStatusMax bound the acceptable response
+	// status range for an HTTP check. Both default to 200 if left zero,
+	// meaning only exactly 200 is accepted.
+	ExpectStatusMin int
+	ExpectStatusMax int
+
+	// ExpectBody, if non-empty, must appear as a substring of the response
+	// body for an HTTP check to be considered healthy.
+	ExpectBody string
+}
+
+func (c *ActiveHealthCheckConfig) applyDefaults() {
+	if c.Interval <= 0 {
+		c.Interval = 30 * time.Second
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 5 * time.Second
+	}
+	if c.ExpectStatusMin == 0 && c.ExpectStatusMax == 0 {
+		c.ExpectStatusMin = 200
+		c.ExpectStatusMax = 200
+	}
+}
+
+// PassiveHealthCheckConfig configures passive health checking driven by
+// live proxied traffic.
+type PassiveHealthCheckConfig struct {
+	// MaxConsecutiveDialErrors is how many dial errors in a row are
+	// tolerated before a host is quarantined. Defaults to 3.
+	MaxConsecutiveDialErrors int
+
+	// MaxConsecutive5xx is how many 5xx responses in a row are tolerated
+	// before a host is quarantined. Defaults to 5.
+	MaxConsecutive5xx int
+
+	// MaxLatency is the dial latency above which a slow dial counts against
+	// MaxConsecutiveSlow. Only the time dialUpstream takes to dial a pool
+	// host is measured; response latency is not. Defaults to disabled (0).
+	MaxLatency time.Duration
+
+	// MaxConsecutiveSlow is how many MaxLatency-exceeding results in a row
+	// are tolerated before a host is quarantined. Defaults to 5.
+	MaxConsecutiveSlow int
+
+	// Cooldown is how long a quarantined host is skipped for. Defaults to
+	// 30 seconds.
+	Cooldown time.Duration
+}
+
+func (c *PassiveHealthCheckConfig) applyDefaults() {
+	if c.MaxConsecutiveDialErrors <= 0 {
+		c.MaxConsecutiveDialErrors = 3
+	}
+	if c.MaxConsecutive5xx <= 0 {
+		c.MaxConsecutive5xx = 5
+	}
+	if c.MaxConsecutiveSlow <= 0 {
+		c.MaxConsecutiveSlow = 5
+	}
+	if c.Cooldown <= 0 {
+		c.Cooldown = 30 * time.Second
+	}
+}
+
+// SetHealthy marks host healthy or unhealthy, clearing or setting its
+// failure cooldown accordingly and resetting the passive-check counters
+// that led to the decision.
+func (h *UpstreamHost) SetHealthy(healthy bool) {
+	if healthy {
+		atomic.StoreInt64(&h.failedUntil, 0)
+		atomic.StoreInt64(&h.consecDialErrors, 0)
+		atomic.StoreInt64(&h.consec5xx, 0)
+		atomic.StoreInt64(&h.consecSlow, 0)
+		return
+	}
+	h.Unhealthy()
+}
+
+// Unhealthy quarantines host for healthChecksDefaultCooldown. The Cooldown
+// of a PassiveHealthCheckConfig is not consulted here.
+func (h *UpstreamHost) Unhealthy() {
+	h.markFailed(healthChecksDefaultCooldown)
+}
+
+const healthChecksDefaultCooldown = 30 * time.Second
+
+// healthChecker runs active checks against a pool's hosts on a ticker and
+// records passive check outcomes reported by dialAndCopy/copy.
+type healthChecker struct {
+	cfg      *HealthChecksConfig
+	pool     UpstreamPool
+	client   *http.Client
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// newHealthChecker starts active health checking (if configured) for pool
+// per cfg, and returns a checker that can also record passive outcomes. The
+// caller is responsible for calling Stop when the proxy is torn down.
+func newHealthChecker(cfg *HealthChecksConfig, pool UpstreamPool) *healthChecker {
+	hc := &healthChecker{cfg: cfg, pool: pool, stop: make(chan struct{})}
+	if cfg.Active != nil {
+		cfg.Active.applyDefaults()
+		hc.client = &http.Client{Timeout: cfg.Active.Timeout}
+		go hc.runActive()
+	}
+	if cfg.Passive != nil {
+		cfg.Passive.applyDefaults()
+	}
+	return hc
+}
+
+// Stop halts active health checking. Safe to call more than once.
+func (hc *healthChecker) Stop() {
+	hc.stopOnce.Do(func() { close(hc.stop) })
+}
+
+func (hc *healthChecker) runActive() {
+	ticker := time.NewTicker(hc.cfg.Active.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-hc.stop:
+			return
+		case <-ticker.C:
+			for _, host := range hc.pool.Hosts() {
+				hc.checkOne(host)
+			}
+		}
+	}
+}
+
+func (hc *healthChecker) checkOne(host *UpstreamHost) {
+	cfg := hc.cfg.Active
+	healthy := false
+	if cfg.Path == "" {
+		conn, err := net.DialTimeout("tcp", host.Addr, cfg.Timeout)
+		if err == nil {
+			conn.Close()
+			healthy = true
+		}
+	} else {
+		resp, err := hc.client.Get("http://" + host.Addr + cfg.Path)
+		if err == nil {
+			defer resp.Body.Close()
+			healthy = resp.StatusCode >= cfg.ExpectStatusMin && resp.StatusCode <= cfg.ExpectStatusMax
+			if healthy && cfg.ExpectBody != "" {
+				body := make([]byte, 4096)
+				n, _ := resp.Body.Read(body)
+				healthy = strings.Contains(string(body[:n]), cfg.ExpectBody)
+			}
+		}
+	}
+	host.SetHealthy(healthy)
+}
+
+// recordDialError registers a dial failure against host for passive health
+// checking, quarantining it once MaxConsecutiveDialErrors is reached.
+func (hc *healthChecker) recordDialError(host *UpstreamHost) {
+	if hc.cfg.Passive == nil || host == nil {
+		return
+	}
+	atomic.StoreInt64(&host.consec5xx, 0)
+	atomic.StoreInt64(&host.consecSlow, 0)
+	if atomic.AddInt64(&host.consecDialErrors, 1) >= int64(hc.cfg.Passive.MaxConsecutiveDialErrors) {
+		host.markFailed(hc.cfg.Passive.Cooldown)
+	}
+}
+
+// recordStatus registers an observed upstream response status against host.
+func (hc *healthChecker) recordStatus(host *UpstreamHost, statusCode int) {
+	if hc.cfg.Passive == nil || host == nil {
+		return
+	}
+	atomic.StoreInt64(&host.consecDialErrors, 0)
+	if statusCode >= 500 {
+		if atomic.AddInt64(&host.consec5xx, 1) >= int64(hc.cfg.Passive.MaxConsecutive5xx) {
+			host.markFailed(hc.cfg.Passive.Cooldown)
+		}
+	} else {
+		atomic.StoreInt64(&host.consec5xx, 0)
+	}
+}
+
+// recordLatency registers an observed dial latency against host.
+func (hc *healthChecker) recordLatency(host *UpstreamHost, latency time.Duration) {
+	if hc.cfg.Passive == nil || host == nil || hc.cfg.Passive.MaxLatency <= 0 {
+		return
+	}
+	if latency > hc.cfg.Passive.MaxLatency {
+		if atomic.AddInt64(&host.consecSlow, 1) >= int64(hc.cfg.Passive.MaxConsecutiveSlow) {
+			host.markFailed(hc.cfg.Passive.Cooldown)
+		}
+	} else {
+		atomic.StoreInt64(&host.consecSlow, 0)
+	}
+}
@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRecordDialErrorQuarantinesAfterThreshold(t *testing.T) {
+	hc := newHealthChecker(&HealthChecksConfig{
+		Passive: &PassiveHealthCheckConfig{MaxConsecutiveDialErrors: 3, Cooldown: time.Minute},
+	}, nil)
+	host := NewUpstreamHost("a")
+
+	hc.recordDialError(host)
+	hc.recordDialError(host)
+	if host.failed() {
+		t.Fatal("expected host to still be healthy before hitting the threshold")
+	}
+
+	hc.recordDialError(host)
+	if !host.failed() {
+		t.Fatal("expected host to be quarantined after MaxConsecutiveDialErrors")
+	}
+}
+
+func TestRecordStatusResetsOnSuccessBetween5xxs(t *testing.T) {
+	hc := newHealthChecker(&HealthChecksConfig{
+		Passive: &PassiveHealthCheckConfig{MaxConsecutive5xx: 2, Cooldown: time.Minute},
+	}, nil)
+	host := NewUpstreamHost("a")
+
+	hc.recordStatus(host, http.StatusInternalServerError)
+	hc.recordStatus(host, http.StatusOK)
+	hc.recordStatus(host, http.StatusInternalServerError)
+	if host.failed() {
+		t.Fatal("expected a healthy response in between to reset the consecutive 5xx counter")
+	}
+
+	hc.recordStatus(host, http.StatusInternalServerError)
+	if !host.failed() {
+		t.Fatal("expected host to be quarantined after MaxConsecutive5xx in a row")
+	}
+}
+
+func TestRecordLatencyQuarantinesSlowHosts(t *testing.T) {
+	hc := newHealthChecker(&HealthChecksConfig{
+		Passive: &PassiveHealthCheckConfig{MaxLatency: time.Millisecond, MaxConsecutiveSlow: 2, Cooldown: time.Minute},
+	}, nil)
+	host := NewUpstreamHost("a")
+
+	hc.recordLatency(host, 10*time.Millisecond)
+	if host.failed() {
+		t.Fatal("expected host to still be healthy before hitting the threshold")
+	}
+	hc.recordLatency(host, 10*time.Millisecond)
+	if !host.failed() {
+		t.Fatal("expected host to be quarantined after MaxConsecutiveSlow slow results in a row")
+	}
+}
+
+func TestRecordLatencyIgnoredWhenMaxLatencyDisabled(t *testing.T) {
+	hc := newHealthChecker(&HealthChecksConfig{
+		Passive: &PassiveHealthCheckConfig{MaxConsecutiveSlow: 1, Cooldown: time.Minute},
+	}, nil)
+	host := NewUpstreamHost("a")
+
+	hc.recordLatency(host, time.Hour)
+	if host.failed() {
+		t.Fatal("expected latency tracking to be a no-op when MaxLatency is unset")
+	}
+}
+
+func TestSetHealthyClearsFailureAndCounters(t *testing.T) {
+	host := NewUpstreamHost("a")
+	host.markFailed(time.Minute)
+	host.consecDialErrors = 2
+
+	host.SetHealthy(true)
+
+	if host.failed() {
+		t.Fatal("expected SetHealthy(true) to clear the failure cooldown")
+	}
+	if host.consecDialErrors != 0 {
+		t.Fatal("expected SetHealthy(true) to reset passive-check counters")
+	}
+}
+
+func TestActiveHealthCheckMarksHostByHTTPStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	addr := srv.Listener.Addr().(*net.TCPAddr)
+	host := NewUpstreamHost(addr.String())
+
+	cfg := &HealthChecksConfig{Active: &ActiveHealthCheckConfig{Path: "/healthz", Timeout: time.Second}}
+	hc := newHealthChecker(cfg, NewUpstreamPool([]*UpstreamHost{host}, nil))
+	defer hc.Stop()
+
+	hc.checkOne(host)
+	if !host.failed() {
+		t.Fatal("expected a non-2xx health check response to mark the host unhealthy")
+	}
+}
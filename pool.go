@@ -0,0 +1,299 @@
+package proxy
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/getlantern/errors"
+	"github.com/getlantern/proxy/filters"
+)
+
+// UpstreamHost is a single upstream address that can be selected by an
+// UpstreamPool.
+type UpstreamHost struct {
+	// Addr is the network address (host:port) of this upstream.
+	Addr string
+
+	// Weight influences how often this host is picked by the Weighted
+	// policy. Hosts with Weight <= 0 are treated as having a weight of 1.
+	Weight int
+
+	activeConns int64
+	failedUntil int64 // unix nanoseconds; 0 means not currently failed
+
+	// Consecutive passive health check failure counters. See
+	// healthchecks.go.
+	consecDialErrors int64
+	consec5xx        int64
+	consecSlow       int64
+}
+
+// NewUpstreamHost creates an UpstreamHost for the given address.
+func NewUpstreamHost(addr string) *UpstreamHost {
+	return &UpstreamHost{Addr: addr}
+}
+
+func (h *UpstreamHost) failed() bool {
+	until := atomic.LoadInt64(&h.failedUntil)
+	return until != 0 && time.Now().UnixNano() < until
+}
+
+func (h *UpstreamHost) markFailed(cooldown time.Duration) {
+	atomic.StoreInt64(&h.failedUntil, time.Now().Add(cooldown).UnixNano())
+}
+
+func (h *UpstreamHost) incConns(delta int64) {
+	atomic.AddInt64(&h.activeConns, delta)
+}
+
+func (h *UpstreamHost) conns() int64 {
+	return atomic.LoadInt64(&h.activeConns)
+}
+
+// Policy picks one of the given hosts for req. hosts only ever contains
+// hosts that aren't currently in their failure cooldown, and is never
+// empty.
+type Policy func(hosts []*UpstreamHost, req *http.Request) *UpstreamHost
+
+// RoundRobin cycles through hosts in order.
+func RoundRobin() Policy {
+	var next uint64
+	return func(hosts []*UpstreamHost, req *http.Request) *UpstreamHost {
+		idx := atomic.AddUint64(&next, 1) - 1
+		return hosts[idx%uint64(len(hosts))]
+	}
+}
+
+// Random picks a host uniformly at random.
+func Random() Policy {
+	return func(hosts []*UpstreamHost, req *http.Request) *UpstreamHost {
+		return hosts[rand.Intn(len(hosts))]
+	}
+}
+
+// LeastConn picks the host with the fewest active connections.
+func LeastConn() Policy {
+	return func(hosts []*UpstreamHost, req *http.Request) *UpstreamHost {
+		best := hosts[0]
+		for _, h := range hosts[1:] {
+			if h.conns() < best.conns() {
+				best = h
+			}
+		}
+		return best
+	}
+}
+
+// IPHash deterministically picks a host based on the client's remote IP, so
+// that a given client consistently lands on the same upstream as long as it
+// stays healthy.
+func IPHash() Policy {
+	return func(hosts []*UpstreamHost, req *http.Request) *UpstreamHost {
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			host = req.RemoteAddr
+		}
+		return hosts[hashString(host)%uint32(len(hosts))]
+	}
+}
+
+// HeaderHash deterministically picks a host based on the value of the given
+// request header.
+func HeaderHash(header string) Policy {
+	return func(hosts []*UpstreamHost, req *http.Request) *UpstreamHost {
+		return hosts[hashString(req.Header.Get(header))%uint32(len(hosts))]
+	}
+}
+
+// Weighted picks hosts randomly in proportion to their Weight.
+func Weighted() Policy {
+	return func(hosts []*UpstreamHost, req *http.Request) *UpstreamHost {
+		total := 0
+		for _, h := range hosts {
+			total += weightOf(h)
+		}
+		r := rand.Intn(total)
+		for _, h := range hosts {
+			r -= weightOf(h)
+			if r < 0 {
+				return h
+			}
+		}
+		return hosts[len(hosts)-1]
+	}
+}
+
+func weightOf(h *UpstreamHost) int {
+	if h.Weight <= 0 {
+		return 1
+	}
+	return h.Weight
+}
+
+func hashString(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// UpstreamPool selects among a set of upstream hosts, skipping ones that are
+// currently in their failure cooldown.
+type UpstreamPool interface {
+	// Select returns the next candidate host for req, or nil if every host
+	// is currently in its failure cooldown.
+	Select(req *http.Request) *UpstreamHost
+
+	// MarkFailed puts host into a failure cooldown so it's skipped by
+	// subsequent calls to Select until the cooldown elapses.
+	MarkFailed(host *UpstreamHost, cooldown time.Duration)
+
+	// Hosts returns the hosts backing this pool.
+	Hosts() []*UpstreamHost
+}
+
+type pool struct {
+	hosts  []*UpstreamHost
+	policy Policy
+}
+
+// NewUpstreamPool creates an UpstreamPool that selects among hosts using
+// policy. If policy is nil, RoundRobin is used.
+func NewUpstreamPool(hosts []*UpstreamHost, policy Policy) UpstreamPool {
+	if policy == nil {
+		policy = RoundRobin()
+	}
+	return &pool{hosts: hosts, policy: policy}
+}
+
+func (p *pool) Hosts() []*UpstreamHost {
+	return p.hosts
+}
+
+func (p *pool) Select(req *http.Request) *UpstreamHost {
+	candidates := make([]*UpstreamHost, 0, len(p.hosts))
+	for _, h := range p.hosts {
+		if !h.failed() {
+			candidates = append(candidates, h)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	return p.policy(candidates, req)
+}
+
+func (p *pool) MarkFailed(host *UpstreamHost, cooldown time.Duration) {
+	host.markFailed(cooldown)
+}
+
+// trackedConn decrements its host's active connection count exactly once,
+// when the connection is closed, so that the LeastConn policy has an
+// accurate view of load.
+type trackedConn struct {
+	net.Conn
+	host *UpstreamHost
+	once sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() { c.host.incConns(-1) })
+	return c.Conn.Close()
+}
+
+// dialUpstream dials an upstream for req. When proxy.Pool is configured, it
+// keeps trying candidate hosts - marking failures and letting them cool down
+// - until either a dial succeeds or proxy.TryDuration elapses. When no pool
+// is configured, it falls back to a single direct dial of fallbackAddr,
+// preserving the pre-pool behavior. req may be nil for callers (like
+// dialAndCopy) that don't have an *http.Request on hand; request-aware
+// policies such as IPHash and HeaderHash require a non-nil req. span
+// receives dial-start/dial-done/dial-error events and, on a successful
+// dial, an "upstreamAddr" tag identifying which address was ultimately
+// used; it must not be nil, so pass filters.NoopSpan() if there's no traced
+// Context available.
+func (proxy *proxy) dialUpstream(isCONNECT bool, req *http.Request, fallbackAddr string, span filters.Span) (net.Conn, *UpstreamHost, error) {
+	if proxy.Pool == nil {
+		span.LogFields(map[string]interface{}{"event": "dial-start", "addr": fallbackAddr})
+		conn, err := proxy.Dial(isCONNECT, "tcp", fallbackAddr)
+		if err != nil {
+			span.LogFields(map[string]interface{}{"event": "dial-error", "error": err.Error()})
+		} else {
+			span.LogFields(map[string]interface{}{"event": "dial-done", "addr": fallbackAddr})
+			span.SetTag("upstreamAddr", fallbackAddr)
+		}
+		return conn, nil, err
+	}
+
+	tryDuration := proxy.TryDuration
+	if tryDuration <= 0 {
+		tryDuration = 10 * time.Second
+	}
+	tryInterval := proxy.TryInterval
+	if tryInterval <= 0 {
+		tryInterval = 100 * time.Millisecond
+	}
+	cooldown := proxy.Cooldown
+	if cooldown <= 0 {
+		cooldown = 10 * time.Second
+	}
+
+	deadline := time.Now().Add(tryDuration)
+	var lastErr error
+	for {
+		host := proxy.Pool.Select(req)
+		if host == nil {
+			if lastErr == nil {
+				lastErr = errors.New("no healthy upstream hosts available")
+			}
+		} else {
+			span.LogFields(map[string]interface{}{"event": "dial-start", "addr": host.Addr})
+			start := time.Now()
+			conn, err := proxy.Dial(isCONNECT, "tcp", host.Addr)
+			if err == nil {
+				span.LogFields(map[string]interface{}{"event": "dial-done", "addr": host.Addr})
+				span.SetTag("upstreamAddr", host.Addr)
+				if proxy.healthChecker != nil {
+					proxy.healthChecker.recordLatency(host, time.Since(start))
+				}
+				host.incConns(1)
+				return &trackedConn{Conn: conn, host: host}, host, nil
+			}
+			span.LogFields(map[string]interface{}{"event": "dial-error", "addr": host.Addr, "error": err.Error()})
+			lastErr = err
+			proxy.Pool.MarkFailed(host, cooldown)
+			if proxy.healthChecker != nil {
+				proxy.healthChecker.recordDialError(host)
+			}
+		}
+		if time.Now().After(deadline) {
+			return nil, nil, lastErr
+		}
+		time.Sleep(tryInterval)
+	}
+}
+
+// reportUpstreamStatus lets callers that received an HTTP response from
+// host (as opposed to a dial error) feed 5xx statuses back into the pool so
+// that flaky upstreams get cooled down even when they accept connections
+// but fail to serve requests.
+func (proxy *proxy) reportUpstreamStatus(host *UpstreamHost, statusCode int) {
+	if proxy.Pool == nil || host == nil {
+		return
+	}
+	if proxy.healthChecker != nil && proxy.healthChecker.cfg.Passive != nil {
+		proxy.healthChecker.recordStatus(host, statusCode)
+		return
+	}
+	if statusCode >= 500 {
+		cooldown := proxy.Cooldown
+		if cooldown <= 0 {
+			cooldown = 10 * time.Second
+		}
+		proxy.Pool.MarkFailed(host, cooldown)
+	}
+}
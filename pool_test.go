@@ -0,0 +1,188 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/getlantern/proxy/filters"
+)
+
+// nopConn is a minimal net.Conn stub for tests that only care about Close.
+type nopConn struct{ net.Conn }
+
+func (c *nopConn) Close() error { return nil }
+
+func newTestRequest(t *testing.T, remoteAddr string) *http.Request {
+	req, err := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = remoteAddr
+	return req
+}
+
+func TestRoundRobinCyclesThroughHosts(t *testing.T) {
+	hosts := []*UpstreamHost{NewUpstreamHost("a"), NewUpstreamHost("b"), NewUpstreamHost("c")}
+	policy := RoundRobin()
+	req := newTestRequest(t, "1.2.3.4:5678")
+
+	var got []string
+	for i := 0; i < 6; i++ {
+		got = append(got, policy(hosts, req).Addr)
+	}
+	want := []string{"a", "b", "c", "a", "b", "c"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+}
+
+func TestLeastConnPicksFewestActiveConns(t *testing.T) {
+	a, b := NewUpstreamHost("a"), NewUpstreamHost("b")
+	a.incConns(3)
+	b.incConns(1)
+	policy := LeastConn()
+
+	got := policy([]*UpstreamHost{a, b}, nil)
+	if got != b {
+		t.Fatalf("expected the host with fewer active conns to be picked, got %v", got.Addr)
+	}
+}
+
+func TestIPHashIsStableForTheSameClient(t *testing.T) {
+	hosts := []*UpstreamHost{NewUpstreamHost("a"), NewUpstreamHost("b"), NewUpstreamHost("c")}
+	policy := IPHash()
+	req := newTestRequest(t, "10.0.0.1:54321")
+
+	first := policy(hosts, req)
+	for i := 0; i < 5; i++ {
+		if got := policy(hosts, req); got != first {
+			t.Fatalf("expected IPHash to consistently pick %v for the same client, got %v", first.Addr, got.Addr)
+		}
+	}
+}
+
+func TestWeightedFavorsHigherWeight(t *testing.T) {
+	heavy := &UpstreamHost{Addr: "heavy", Weight: 99}
+	light := &UpstreamHost{Addr: "light", Weight: 1}
+	policy := Weighted()
+
+	counts := map[string]int{}
+	for i := 0; i < 200; i++ {
+		counts[policy([]*UpstreamHost{heavy, light}, nil).Addr]++
+	}
+	if counts["heavy"] <= counts["light"] {
+		t.Fatalf("expected the heavily-weighted host to be picked far more often, got %v", counts)
+	}
+}
+
+func TestPoolSelectSkipsFailedHosts(t *testing.T) {
+	a, b := NewUpstreamHost("a"), NewUpstreamHost("b")
+	p := NewUpstreamPool([]*UpstreamHost{a, b}, RoundRobin())
+
+	p.MarkFailed(a, time.Minute)
+
+	for i := 0; i < 4; i++ {
+		if got := p.Select(nil); got != b {
+			t.Fatalf("expected only the healthy host to be selected, got %v", got.Addr)
+		}
+	}
+}
+
+func TestPoolSelectReturnsNilWhenAllHostsFailed(t *testing.T) {
+	a := NewUpstreamHost("a")
+	p := NewUpstreamPool([]*UpstreamHost{a}, RoundRobin())
+	p.MarkFailed(a, time.Minute)
+
+	if got := p.Select(nil); got != nil {
+		t.Fatalf("expected nil when every host is failed, got %v", got.Addr)
+	}
+}
+
+func TestPoolSelectRecoversAfterCooldown(t *testing.T) {
+	a := NewUpstreamHost("a")
+	p := NewUpstreamPool([]*UpstreamHost{a}, RoundRobin())
+	p.MarkFailed(a, time.Millisecond)
+
+	time.Sleep(5 * time.Millisecond)
+
+	if got := p.Select(nil); got != a {
+		t.Fatal("expected the host to be selectable again once its cooldown elapsed")
+	}
+}
+
+func TestTrackedConnDecrementsConnsOnlyOnce(t *testing.T) {
+	host := NewUpstreamHost("a")
+	host.incConns(1)
+	conn := &trackedConn{Conn: &nopConn{}, host: host}
+
+	conn.Close()
+	conn.Close()
+
+	if got := host.conns(); got != 0 {
+		t.Fatalf("expected active conns to be decremented exactly once, got %d", got)
+	}
+}
+
+func TestDialUpstreamFailsOverToAHealthyHostAndQuarantinesTheBadOne(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	bad := NewUpstreamHost("127.0.0.1:1")
+	good := NewUpstreamHost(ln.Addr().String())
+	p := NewUpstreamPool([]*UpstreamHost{bad, good}, RoundRobin())
+
+	px := &proxy{Opts: &Opts{
+		Dial:        func(isCONNECT bool, network, addr string) (net.Conn, error) { return net.Dial(network, addr) },
+		Pool:        p,
+		TryDuration: time.Second,
+		TryInterval: time.Millisecond,
+		Cooldown:    time.Minute,
+	}}
+
+	conn, host, err := px.dialUpstream(true, nil, "", filters.NoopSpan())
+	if err != nil {
+		t.Fatalf("expected dialUpstream to fail over to the healthy host, got error: %v", err)
+	}
+	defer conn.Close()
+
+	if host != good {
+		t.Fatalf("expected dialUpstream to return the healthy host, got %v", host.Addr)
+	}
+	if !bad.failed() {
+		t.Fatal("expected the unreachable host to be quarantined after its dial failure")
+	}
+}
+
+func TestDialUpstreamGivesUpAfterTryDurationWhenAllHostsFail(t *testing.T) {
+	bad := NewUpstreamHost("127.0.0.1:1")
+	p := NewUpstreamPool([]*UpstreamHost{bad}, RoundRobin())
+
+	px := &proxy{Opts: &Opts{
+		Dial:        func(isCONNECT bool, network, addr string) (net.Conn, error) { return net.Dial(network, addr) },
+		Pool:        p,
+		TryDuration: 20 * time.Millisecond,
+		TryInterval: time.Millisecond,
+		Cooldown:    time.Minute,
+	}}
+
+	_, _, err := px.dialUpstream(true, nil, "", filters.NoopSpan())
+	if err == nil {
+		t.Fatal("expected dialUpstream to give up once TryDuration elapses with every host unreachable")
+	}
+}
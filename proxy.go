@@ -0,0 +1,269 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/getlantern/golog"
+	"github.com/getlantern/proxy/filters"
+	"github.com/getlantern/proxy/transport"
+)
+
+var log = golog.LoggerFor("proxy")
+
+// DialFunc is the dial function to use for dialing the proxy.
+type DialFunc func(isCONNECT bool, network, addr string) (net.Conn, error)
+
+// OnError is an optional function that's invoked whenever the proxy runs
+// into an error. This gives the caller an opportunity to log the error,
+// send it to an error reporting service, etc.
+type OnError func(req *http.Request, read bool, err error) *http.Response
+
+// Proxy is an HTTP proxy that can act as both a forward and reverse proxy
+// for plain HTTP and CONNECT/HTTPS traffic.
+type Proxy interface {
+	// Handle handles a single connection from a client, blocking until the
+	// connection is done.
+	Handle(conn net.Conn) error
+
+	// Handler adapts this Proxy to an http.Handler for use as a reverse
+	// proxy.
+	Handler() http.Handler
+
+	// Close shuts down background resources this Proxy owns - currently
+	// just its active health-check ticker, if Opts.HealthChecks.Active was
+	// configured. It does not close any in-flight connections.
+	Close() error
+}
+
+// Opts configures a Proxy.
+type Opts struct {
+	// Filter is the filter chain to apply to incoming requests.
+	Filter filters.Filter
+
+	// OnError is called whenever the proxy encounters an error.
+	OnError OnError
+
+	// Dial is used to dial upstream. If not specified, defaults to
+	// net.Dial.
+	Dial DialFunc
+
+	// OKWaitsForUpstream indicates that a 200 OK should only be sent for a
+	// CONNECT request once the proxy has confirmed that it can reach an
+	// upstream. If false, the proxy replies OK immediately.
+	OKWaitsForUpstream bool
+
+	// IdleTimeout, if specified, lets us know to include an appropriate
+	// KeepAlive header in responses and to close connections that have been
+	// idle for longer than this period.
+	IdleTimeout time.Duration
+
+	// BufferSource specifies a BufferSource to use for allocating buffers
+	// used in reading/writing. If not specified, a default source is used.
+	BufferSource BufferSource
+
+	// Pool, if specified, is consulted for each CONNECT/HTTP request in
+	// place of dialing the request's own Host directly. This lets a single
+	// proxy instance load-balance and fail over across a set of upstream
+	// exit nodes. See UpstreamPool and NewUpstreamPool.
+	Pool UpstreamPool
+
+	// TryDuration bounds how long to keep trying candidate hosts from Pool
+	// before giving up. Defaults to 10 seconds. Ignored if Pool is nil.
+	TryDuration time.Duration
+
+	// TryInterval is how long to wait between successive attempts against
+	// different hosts from Pool. Defaults to 100 milliseconds. Ignored if
+	// Pool is nil.
+	TryInterval time.Duration
+
+	// Cooldown is how long a host from Pool is skipped for after a dial
+	// failure or a 5xx response. Defaults to 10 seconds. Ignored if Pool is
+	// nil.
+	Cooldown time.Duration
+
+	// HealthChecks, if specified, enables active and/or passive health
+	// checking of Pool's hosts. Ignored if Pool is nil.
+	HealthChecks *HealthChecksConfig
+
+	// Transport, if specified, is used to reach upstreams instead of Dial,
+	// letting the proxy speak something other than raw TCP to the upstream
+	// carrier (e.g. FastCGI). Defaults to a transport.Direct that dials with
+	// Dial (or net.Dial if Dial is nil), which preserves the original
+	// raw-dial behavior.
+	Transport transport.Transport
+
+	// Tracer, if specified, is attached (via filters.ContextWithTracer) to
+	// the Context built for each connection, so that filters.Chain.apply
+	// creates a child span per filter. Defaults to filters.DefaultTracer,
+	// a no-op.
+	Tracer filters.Tracer
+}
+
+type proxy struct {
+	*Opts
+	healthChecker *healthChecker
+}
+
+// New creates a new Proxy configured with the given Opts.
+func New(opts *Opts) Proxy {
+	opts.applyCONNECTDefaults()
+	if opts.Transport == nil {
+		// Default to dialing with opts.Dial, preserving the pre-Transport
+		// behavior for anyone relying on a custom Dial (proxy chaining,
+		// rate limiting, instrumentation) without also configuring Pool or
+		// Transport. Without this, the default transport.NewDirect(nil)
+		// would silently fall back to net.Dial for the CONNECT-without-Pool
+		// path.
+		dial := opts.Dial
+		opts.Transport = transport.NewDirect(func(network, addr string) (net.Conn, error) {
+			if dial != nil {
+				return dial(true, network, addr)
+			}
+			return net.Dial(network, addr)
+		})
+	}
+	if opts.Tracer == nil {
+		opts.Tracer = filters.DefaultTracer
+	}
+	p := &proxy{Opts: opts}
+	if opts.Pool != nil && opts.HealthChecks != nil {
+		p.healthChecker = newHealthChecker(opts.HealthChecks, opts.Pool)
+	}
+	return p
+}
+
+// Handle reads requests off downstream until it sees a CONNECT (at which
+// point it tunnels for the remainder of the connection's life) or the
+// connection closes. Each request runs through proxy.Filter before
+// dispatching to the CONNECT or plain-HTTP machinery in proxy_connect.go,
+// so that pool failover, health checking, buffer pooling, Transport, and
+// tracing all actually get exercised.
+func (proxy *proxy) Handle(downstream net.Conn) error {
+	defer downstream.Close()
+	reader := bufio.NewReader(downstream)
+	requestNumber := 0
+	for {
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		requestNumber++
+		ctx := proxy.newProxyContext(downstream, requestNumber)
+
+		if req.Method == http.MethodConnect {
+			return proxy.handleCONNECT(ctx, req, downstream)
+		}
+
+		resp, _, err := proxy.applyFilters(ctx, req, proxy.nextHTTP())
+		if err != nil {
+			return err
+		}
+		if resp != nil {
+			if writeErr := resp.Write(downstream); writeErr != nil {
+				return writeErr
+			}
+		}
+	}
+}
+
+// handleCONNECT runs req (a CONNECT) through the filter chain, writes
+// whatever response the chain produced to downstream, and - if the chain
+// dialed an upstream - tunnels bytes between them until one side closes.
+func (proxy *proxy) handleCONNECT(ctx filters.Context, req *http.Request, downstream net.Conn) error {
+	resp, nextCtx, err := proxy.applyFilters(ctx, req, proxy.nextCONNECT(downstream))
+	if err != nil {
+		return err
+	}
+	if resp != nil {
+		if writeErr := resp.Write(downstream); writeErr != nil {
+			return writeErr
+		}
+	}
+
+	upstream, ok := nextCtx.Value(ctxKeyUpstream).(net.Conn)
+	if !ok {
+		// !OKWaitsForUpstream already wrote the OK response above without
+		// dialing anything, recording only the target address so we can
+		// dial and tunnel now that the response is out the door.
+		addr, ok := nextCtx.Value(ctxKeyUpstreamAddr).(string)
+		if !ok {
+			// The chain short-circuited without dialing or scheduling a
+			// dial at all (e.g. a filter rejected the request), so there's
+			// nothing left to tunnel.
+			return nil
+		}
+		return proxy.dialAndCopy(addr, downstream)
+	}
+	upstreamHost, _ := nextCtx.Value(ctxKeyUpstreamHost).(*UpstreamHost)
+	return proxy.copy(upstream, upstreamHost, downstream, filters.SpanFromContext(nextCtx))
+}
+
+// applyFilters runs req through proxy.Filter if one is configured,
+// otherwise it just invokes next directly.
+func (proxy *proxy) applyFilters(ctx filters.Context, req *http.Request, next filters.Next) (*http.Response, filters.Context, error) {
+	if proxy.Filter == nil {
+		return next(ctx, req)
+	}
+	return proxy.Filter.Apply(ctx, req, next)
+}
+
+// nextHTTP is the terminal filters.Next for plain (non-CONNECT) requests,
+// dispatching to the configured Transport (or, when proxy.Pool is
+// configured, a pool-selected host) via proxy.roundTrip, and feeding the
+// response status back into passive health checking via
+// reportUpstreamStatus.
+func (proxy *proxy) nextHTTP() filters.Next {
+	return func(ctx filters.Context, req *http.Request) (*http.Response, filters.Context, error) {
+		resp, upstreamHost, err := proxy.roundTrip(req, filters.SpanFromContext(ctx))
+		if err != nil {
+			return badGateway(ctx, req, err)
+		}
+		proxy.reportUpstreamStatus(upstreamHost, resp.StatusCode)
+		return resp, ctx, nil
+	}
+}
+
+// Handler adapts Handle's plain-HTTP path to an http.Handler, for use as a
+// reverse proxy in front of an existing net/http server.
+func (proxy *proxy) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := proxy.newProxyContext(nil, 1)
+		resp, _, err := proxy.applyFilters(ctx, req, proxy.nextHTTP())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		for name, values := range resp.Header {
+			for _, value := range values {
+				w.Header().Add(name, value)
+			}
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	})
+}
+
+// Close stops this proxy's active health checker, if one was started. It's
+// safe to call even if Opts.HealthChecks was never configured.
+func (proxy *proxy) Close() error {
+	if proxy.healthChecker != nil {
+		proxy.healthChecker.Stop()
+	}
+	return nil
+}
+
+func (proxy *proxy) addIdleKeepAlive(header http.Header) {
+	if proxy.IdleTimeout > 0 {
+		seconds := int(proxy.IdleTimeout.Seconds())
+		header.Set("Keep-Alive", "timeout="+strconv.Itoa(seconds))
+	}
+}
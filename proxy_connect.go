@@ -1,10 +1,13 @@
 package proxy
 
 import (
+	"bufio"
 	"io"
 	"net"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/getlantern/errors"
@@ -15,6 +18,11 @@ import (
 )
 
 // BufferSource is a source for buffers used in reading/writing.
+//
+// Implementations may additionally implement GetSized(n int) []byte to
+// hand back a right-sized buffer to a caller that has a size hint
+// available (see NewTieredBufferSource and sizedBufferSource); proxy.copy
+// doesn't have one to offer for CONNECT tunnels, so it always uses Get().
 type BufferSource interface {
 	Get() []byte
 	Put(buf []byte)
@@ -58,7 +66,13 @@ func (proxy *proxy) nextCONNECT(downstream net.Conn) filters.Next {
 		// Note - for CONNECT requests, we use the Host from the request URL, not the
 		// Host header. See discussion here:
 		// https://ask.wireshark.org/questions/22988/http-host-header-with-and-without-port-number
-		upstream, err := proxy.Dial(true, "tcp", modifiedReq.URL.Host)
+		//
+		// When proxy.Pool is configured, this tries candidate upstream hosts
+		// from the pool instead of dialing modifiedReq.URL.Host directly,
+		// failing over between them until one succeeds or TryDuration
+		// elapses.
+		span := filters.SpanFromContext(ctx)
+		upstream, upstreamHost, err := proxy.dialUpstream(true, modifiedReq, modifiedReq.URL.Host, span)
 		if err != nil {
 			if proxy.OKWaitsForUpstream {
 				return badGateway(ctx, modifiedReq, err)
@@ -77,38 +91,150 @@ func (proxy *proxy) nextCONNECT(downstream net.Conn) filters.Next {
 			StatusCode: http.StatusOK,
 		})
 		nextCtx = contextWithValue(nextCtx, ctxKeyUpstream, upstream)
+		if upstreamHost != nil {
+			// Record which pool host served this request so that filters
+			// further down the chain (and callers reporting back upstream
+			// errors, see reportUpstreamStatus) can identify it.
+			nextCtx = contextWithValue(nextCtx, ctxKeyUpstreamHost, upstreamHost)
+		}
 		return resp, nextCtx, nil
 	}
 }
 
+// dialAndCopy dials addr and tunnels downstream to it, honoring
+// proxy.Pool/Transport. handleCONNECT calls this when !OKWaitsForUpstream,
+// since nextCONNECT responds OK immediately in that mode without dialing
+// anything itself.
 func (proxy *proxy) dialAndCopy(addr string, downstream net.Conn) error {
-	upstream, err := proxy.Dial(true, "tcp", addr)
+	if proxy.Pool == nil {
+		// No pool configured, so hand the tunnel off to the configured
+		// Transport wholesale. The default Transport (transport.Direct)
+		// dials addr and pipes bytes exactly as this function used to do
+		// itself; other transports (e.g. FastCGI) may not support Hijack
+		// at all, in which case they should return an error here.
+		return proxy.Transport.Hijack(downstream, addr)
+	}
+	upstream, upstreamHost, err := proxy.dialUpstream(true, nil, addr, filters.NoopSpan())
 	if err != nil {
 		return err
 	}
-	return proxy.copy(upstream, downstream)
+	return proxy.copy(upstream, upstreamHost, downstream, filters.NoopSpan())
+}
+
+// roundTrip performs req against the configured Transport, or - when
+// proxy.Pool is configured - against a pool-selected host with the same
+// failover and passive-health-check reporting the CONNECT path gets from
+// dialUpstream. Transport implementations dial their own upstream (e.g.
+// transport.Direct dials req.URL.Host, transport.FastCGI dials its
+// configured Addr) and have no hook for being handed a pool-selected host,
+// so when a Pool is configured we bypass Transport entirely and dial/write/
+// read the request ourselves via poolRoundTrip - the same way dialUpstream
+// already bypasses Transport for CONNECT.
+func (proxy *proxy) roundTrip(req *http.Request, span filters.Span) (*http.Response, *UpstreamHost, error) {
+	if proxy.Pool == nil {
+		resp, err := proxy.Transport.RoundTrip(req)
+		return resp, nil, err
+	}
+	return proxy.poolRoundTrip(req, span)
+}
+
+// poolRoundTrip writes req to a pool-selected host and parses back a single
+// HTTP response, retrying candidate hosts and recording failures the same
+// way dialUpstream's CONNECT callers do.
+func (proxy *proxy) poolRoundTrip(req *http.Request, span filters.Span) (*http.Response, *UpstreamHost, error) {
+	upstream, upstreamHost, err := proxy.dialUpstream(false, req, req.URL.Host, span)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := req.Write(upstream); err != nil {
+		upstream.Close()
+		return nil, upstreamHost, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(upstream), req)
+	if err != nil {
+		upstream.Close()
+		return nil, upstreamHost, err
+	}
+	resp.Body = &poolConnClosingBody{ReadCloser: resp.Body, conn: upstream}
+	return resp, upstreamHost, nil
+}
+
+// poolConnClosingBody closes the pool-dialed connection a poolRoundTrip
+// response body reads from once the caller's done with the body, mirroring
+// transport.Direct's connClosingBody.
+type poolConnClosingBody struct {
+	io.ReadCloser
+	conn net.Conn
+}
+
+func (b *poolConnClosingBody) Close() error {
+	err := b.ReadCloser.Close()
+	if closeErr := b.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
 }
 
-func (proxy *proxy) copy(upstream, downstream net.Conn) error {
+// copy pipes data between upstream and downstream until one side closes.
+// upstreamHost, if non-nil, identifies the pool host that upstream was
+// dialed from and is used to feed connection-level failures back into
+// passive health checking. span receives a "first-byte" event the first
+// time any data moves in either direction, and a "close" event with the
+// total bytes copied in each direction once the tunnel ends; pass
+// filters.NoopSpan() if there's no traced Context available.
+func (proxy *proxy) copy(upstream net.Conn, upstreamHost *UpstreamHost, downstream net.Conn, span filters.Span) error {
+	var bytesToUpstream, bytesToDownstream int64
 	defer func() {
+		span.LogFields(map[string]interface{}{
+			"event":             "close",
+			"bytesToUpstream":   atomic.LoadInt64(&bytesToUpstream),
+			"bytesToDownstream": atomic.LoadInt64(&bytesToDownstream),
+		})
 		if closeErr := upstream.Close(); closeErr != nil {
 			log.Tracef("Error closing upstream connection: %s", closeErr)
 		}
 	}()
 
-	// Pipe data between the client and the proxy.
+	// Pipe data between the client and the proxy. CONNECT tunnels carry no
+	// declared length to hint a buffer size from, so we always use
+	// BufferSource's default-sized buffer here; a BufferSource that also
+	// implements GetSized (see bufferpool.go) is available for callers
+	// that do have a size hint, such as tests or future Transports.
 	bufOut := proxy.BufferSource.Get()
 	bufIn := proxy.BufferSource.Get()
 	defer proxy.BufferSource.Put(bufOut)
 	defer proxy.BufferSource.Put(bufIn)
-	writeErr, readErr := netx.BidiCopy(upstream, downstream, bufOut, bufIn)
+
+	var firstByteOnce sync.Once
+	onFirstByte := func() {
+		firstByteOnce.Do(func() { span.LogFields(map[string]interface{}{"event": "first-byte"}) })
+	}
+	outErrCh, inErrCh := netx.BidiCopyWithOpts(upstream, downstream, &netx.CopyOpts{
+		BufOut: bufOut,
+		BufIn:  bufIn,
+		OnOut: func(n int) {
+			onFirstByte()
+			atomic.AddInt64(&bytesToUpstream, int64(n))
+		},
+		OnIn: func(n int) {
+			onFirstByte()
+			atomic.AddInt64(&bytesToDownstream, int64(n))
+		},
+	})
+	writeErr, readErr := <-outErrCh, <-inErrCh
 	// Note - we ignore idled errors because these are okay per the HTTP spec.
 	// See https://www.w3.org/Protocols/rfc2616/rfc2616-sec8.html#sec8.1.4
 	// We also ignore "broken pipe" errors on piping to downstream because they're
 	// usually caused by the client disconnecting and we don't worry about that.
 	if readErr != nil && readErr != io.EOF && !strings.Contains(readErr.Error(), "broken pipe") {
+		if proxy.healthChecker != nil {
+			proxy.healthChecker.recordDialError(upstreamHost)
+		}
 		return errors.New("Error piping data to downstream: %v", readErr)
 	} else if writeErr != nil && writeErr != idletiming.ErrIdled {
+		if proxy.healthChecker != nil {
+			proxy.healthChecker.recordDialError(upstreamHost)
+		}
 		return errors.New("Error piping data to upstream: %v", writeErr)
 	}
 	return nil
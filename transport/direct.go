@@ -0,0 +1,90 @@
+package transport
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+)
+
+// DialFunc dials a network address. It mirrors proxy.DialFunc so that a
+// proxy's existing dial function can be reused to build a Direct
+// transport.
+type DialFunc func(network, addr string) (net.Conn, error)
+
+// Direct is a Transport that reaches the upstream by dialing a plain
+// network connection to it. This is the behavior the proxy package used
+// before Transport existed.
+type Direct struct {
+	dial DialFunc
+}
+
+// NewDirect returns a Direct transport that dials upstreams with dial. If
+// dial is nil, net.Dial is used.
+func NewDirect(dial DialFunc) *Direct {
+	if dial == nil {
+		dial = net.Dial
+	}
+	return &Direct{dial: dial}
+}
+
+// RoundTrip dials req.URL.Host, writes req to it, and reads back a single
+// HTTP response.
+func (d *Direct) RoundTrip(req *http.Request) (*http.Response, error) {
+	conn, err := d.dial("tcp", req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// Close conn once the caller is done reading the body.
+	resp.Body = &connClosingBody{ReadCloser: resp.Body, conn: conn}
+	return resp, nil
+}
+
+type connClosingBody struct {
+	io.ReadCloser
+	conn net.Conn
+}
+
+func (b *connClosingBody) Close() error {
+	err := b.ReadCloser.Close()
+	if closeErr := b.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Hijack dials addr and pipes bytes between it and downstream until either
+// side closes.
+func (d *Direct) Hijack(downstream net.Conn, addr string) error {
+	defer downstream.Close()
+	upstream, err := d.dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer upstream.Close()
+
+	errc := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(upstream, downstream)
+		errc <- err
+	}()
+	go func() {
+		_, err := io.Copy(downstream, upstream)
+		errc <- err
+	}()
+	firstErr := <-errc
+	// Close both ends so the copy in the other direction unblocks.
+	upstream.Close()
+	downstream.Close()
+	<-errc
+	return firstErr
+}
@@ -0,0 +1,181 @@
+package transport
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// FastCGI is a Transport that speaks the FastCGI protocol to a backend
+// (e.g. php-fpm) instead of dialing a raw TCP connection and writing an
+// HTTP request to it. This lets the proxy front PHP/Python app servers
+// directly, while still running requests through the existing filter
+// chain.
+type FastCGI struct {
+	// Dial is used to reach the FastCGI backend. If nil, net.Dial is used.
+	Dial DialFunc
+
+	// Network is the network passed to Dial, e.g. "tcp" or "unix".
+	// Defaults to "tcp".
+	Network string
+
+	// Addr is the address (host:port, or a unix socket path) of the
+	// FastCGI backend.
+	Addr string
+
+	// Root is the document root used to compute SCRIPT_FILENAME from the
+	// request path.
+	Root string
+}
+
+// NewFastCGI returns a FastCGI transport that dials addr over network
+// (defaulting to "tcp") to reach a backend rooted at root.
+func NewFastCGI(network, addr, root string) *FastCGI {
+	if network == "" {
+		network = "tcp"
+	}
+	return &FastCGI{Network: network, Addr: addr, Root: root}
+}
+
+func (f *FastCGI) dial() (net.Conn, error) {
+	network := f.Network
+	if network == "" {
+		network = "tcp"
+	}
+	if f.Dial != nil {
+		return f.Dial(network, f.Addr)
+	}
+	return net.Dial(network, f.Addr)
+}
+
+// RoundTrip builds the FastCGI param map from req, opens a FastCGI request
+// record, streams req.Body as stdin, and demultiplexes the backend's
+// stdout/stderr records into an *http.Response.
+func (f *FastCGI) RoundTrip(req *http.Request) (*http.Response, error) {
+	conn, err := f.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeBeginRequest(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := writeParams(conn, f.params(req)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := writeStdin(conn, req.Body); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	stdout, _, err := readResponseRecords(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	resp, err := parseFastCGIResponse(req, stdout)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body = &connClosingBody{ReadCloser: resp.Body, conn: conn}
+	return resp, nil
+}
+
+// Hijack always fails: FastCGI is a request/response protocol and doesn't
+// support proxying an opaque CONNECT tunnel to a backend.
+func (f *FastCGI) Hijack(downstream net.Conn, addr string) error {
+	downstream.Close()
+	return fmt.Errorf("transport: FastCGI does not support CONNECT tunneling to %s", addr)
+}
+
+// params builds the FastCGI param map for req, following the conventions
+// CGI/FastCGI backends expect.
+func (f *FastCGI) params(req *http.Request) map[string]string {
+	params := map[string]string{
+		"SCRIPT_FILENAME": strings.TrimRight(f.Root, "/") + req.URL.Path,
+		"PATH_INFO":       req.URL.Path,
+		"QUERY_STRING":    req.URL.RawQuery,
+		"REQUEST_METHOD":  req.Method,
+		"SERVER_PROTOCOL": req.Proto,
+		"REMOTE_ADDR":     remoteHost(req.RemoteAddr),
+		"CONTENT_TYPE":    req.Header.Get("Content-Type"),
+	}
+	// req.ContentLength is -1 when the length is unknown (e.g. a chunked
+	// body); leave CONTENT_LENGTH unset in that case rather than sending a
+	// backend a value it'll try to parse as a byte count.
+	if req.ContentLength >= 0 {
+		params["CONTENT_LENGTH"] = strconv.FormatInt(req.ContentLength, 10)
+	}
+	if req.TLS != nil {
+		params["HTTPS"] = "on"
+	}
+	for name, values := range req.Header {
+		key := "HTTP_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		params[key] = strings.Join(values, ", ")
+	}
+	return params
+}
+
+func remoteHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// parseFastCGIResponse parses the CGI-style header block a FastCGI backend
+// writes to stdout (headers, a blank line, then the body) into an
+// *http.Response.
+func parseFastCGIResponse(req *http.Request, stdout *bytes.Buffer) (*http.Response, error) {
+	raw := stdout.Bytes()
+	headerBytes, bodyBytes := raw, []byte(nil)
+	if sep := bytes.Index(raw, []byte("\r\n\r\n")); sep >= 0 {
+		headerBytes, bodyBytes = raw[:sep], raw[sep+4:]
+	}
+
+	resp := &http.Response{
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Request:    req,
+	}
+	for _, line := range bytes.Split(headerBytes, []byte("\r\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		parts := bytes.SplitN(line, []byte(":"), 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name := string(bytes.TrimSpace(parts[0]))
+		value := string(bytes.TrimSpace(parts[1]))
+		if strings.EqualFold(name, "Status") {
+			if fields := strings.Fields(value); len(fields) > 0 {
+				if code, err := strconv.Atoi(fields[0]); err == nil {
+					resp.StatusCode = code
+				}
+			}
+			continue
+		}
+		resp.Header.Add(name, value)
+	}
+	resp.Body = nopCloser{bytes.NewReader(bodyBytes)}
+	resp.ContentLength = int64(len(bodyBytes))
+	return resp, nil
+}
+
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,172 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+)
+
+// This file implements just enough of the FastCGI wire protocol (see
+// https://fastcgi-archives.github.io/FastCGI_Specification.html) to drive a
+// single responder request per connection, which is all FastCGI.RoundTrip
+// needs.
+
+const (
+	fcgiVersion1 = 1
+
+	fcgiBeginRequest = 1
+	fcgiEndRequest   = 3
+	fcgiParams       = 4
+	fcgiStdin        = 5
+	fcgiStdout       = 6
+	fcgiStderr       = 7
+
+	fcgiRoleResponder = 1
+
+	// We only ever run a single request per connection, so we can hardcode
+	// the request ID rather than tracking a pool of them.
+	fcgiRequestID = 1
+
+	// maxRecordContent is the largest content length a single FastCGI
+	// record can carry; longer payloads must be split across records.
+	maxRecordContent = 65535
+)
+
+type fcgiHeader struct {
+	Version       uint8
+	Type          uint8
+	RequestID     uint16
+	ContentLength uint16
+	PaddingLength uint8
+	Reserved      uint8
+}
+
+func (h *fcgiHeader) init(recType uint8, contentLength int) {
+	h.Version = fcgiVersion1
+	h.Type = recType
+	h.RequestID = uint16(fcgiRequestID)
+	h.ContentLength = uint16(contentLength)
+	h.PaddingLength = uint8(-contentLength & 7)
+}
+
+func writeRecord(w io.Writer, recType uint8, content []byte) error {
+	var h fcgiHeader
+	h.init(recType, len(content))
+	if err := binary.Write(w, binary.BigEndian, h); err != nil {
+		return err
+	}
+	if len(content) > 0 {
+		if _, err := w.Write(content); err != nil {
+			return err
+		}
+	}
+	if h.PaddingLength > 0 {
+		if _, err := w.Write(make([]byte, h.PaddingLength)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeBeginRequest(w io.Writer) error {
+	body := make([]byte, 8)
+	binary.BigEndian.PutUint16(body[0:2], fcgiRoleResponder)
+	// Flags left at 0: don't ask the backend to keep the connection open,
+	// since we open a fresh connection per request.
+	return writeRecord(w, fcgiBeginRequest, body)
+}
+
+// encodeSize writes a FastCGI name/value length: one byte if it fits in 7
+// bits, or 4 bytes with the high bit set otherwise.
+func encodeSize(buf *bytes.Buffer, size int) {
+	if size <= 127 {
+		buf.WriteByte(byte(size))
+		return
+	}
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(size)|0x80000000)
+	buf.Write(b[:])
+}
+
+func encodeParams(params map[string]string) []byte {
+	var buf bytes.Buffer
+	for name, value := range params {
+		encodeSize(&buf, len(name))
+		encodeSize(&buf, len(value))
+		buf.WriteString(name)
+		buf.WriteString(value)
+	}
+	return buf.Bytes()
+}
+
+func writeParams(w io.Writer, params map[string]string) error {
+	encoded := encodeParams(params)
+	for len(encoded) > 0 {
+		n := len(encoded)
+		if n > maxRecordContent {
+			n = maxRecordContent
+		}
+		if err := writeRecord(w, fcgiParams, encoded[:n]); err != nil {
+			return err
+		}
+		encoded = encoded[n:]
+	}
+	// A zero-length PARAMS record signals end of stream.
+	return writeRecord(w, fcgiParams, nil)
+}
+
+func writeStdin(w io.Writer, body io.ReadCloser) error {
+	if body != nil {
+		defer body.Close()
+		buf := make([]byte, maxRecordContent)
+		for {
+			n, err := body.Read(buf)
+			if n > 0 {
+				if werr := writeRecord(w, fcgiStdin, buf[:n]); werr != nil {
+					return werr
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	// A zero-length STDIN record signals end of stream.
+	return writeRecord(w, fcgiStdin, nil)
+}
+
+// readResponseRecords reads records off r until FCGI_END_REQUEST,
+// demultiplexing FCGI_STDOUT and FCGI_STDERR content into separate
+// buffers.
+func readResponseRecords(r io.Reader) (stdout, stderr *bytes.Buffer, err error) {
+	stdout, stderr = &bytes.Buffer{}, &bytes.Buffer{}
+	br := bufio.NewReader(r)
+	for {
+		var h fcgiHeader
+		if err := binary.Read(br, binary.BigEndian, &h); err != nil {
+			return nil, nil, err
+		}
+		content := make([]byte, h.ContentLength)
+		if _, err := io.ReadFull(br, content); err != nil {
+			return nil, nil, err
+		}
+		if h.PaddingLength > 0 {
+			if _, err := io.CopyN(ioutil.Discard, br, int64(h.PaddingLength)); err != nil {
+				return nil, nil, err
+			}
+		}
+		switch h.Type {
+		case fcgiStdout:
+			stdout.Write(content)
+		case fcgiStderr:
+			stderr.Write(content)
+		case fcgiEndRequest:
+			return stdout, stderr, nil
+		}
+	}
+}
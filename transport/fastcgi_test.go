@@ -0,0 +1,195 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEncodeSizeShortAndLongForms(t *testing.T) {
+	var buf bytes.Buffer
+	encodeSize(&buf, 42)
+	if got := buf.Bytes(); len(got) != 1 || got[0] != 42 {
+		t.Fatalf("expected a single-byte encoding for sizes <= 127, got %v", got)
+	}
+
+	buf.Reset()
+	encodeSize(&buf, 300)
+	got := buf.Bytes()
+	if len(got) != 4 {
+		t.Fatalf("expected a 4-byte encoding for sizes > 127, got %v", got)
+	}
+	if size := binary.BigEndian.Uint32(got) &^ 0x80000000; size != 300 {
+		t.Fatalf("expected decoded size 300, got %d", size)
+	}
+}
+
+func TestWriteRecordPadsContentToA8ByteBoundary(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeRecord(&buf, fcgiStdout, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	var h fcgiHeader
+	if err := binary.Read(&buf, binary.BigEndian, &h); err != nil {
+		t.Fatal(err)
+	}
+	if h.ContentLength != 5 {
+		t.Fatalf("expected content length 5, got %d", h.ContentLength)
+	}
+	if h.PaddingLength != 3 {
+		t.Fatalf("expected 3 bytes of padding to reach an 8-byte boundary, got %d", h.PaddingLength)
+	}
+	if buf.Len() != int(h.ContentLength)+int(h.PaddingLength) {
+		t.Fatalf("expected %d remaining bytes, got %d", h.ContentLength+uint16(h.PaddingLength), buf.Len())
+	}
+}
+
+func TestReadResponseRecordsDemultiplexesStdoutAndStderr(t *testing.T) {
+	var buf bytes.Buffer
+	writeRecord(&buf, fcgiStdout, []byte("out"))
+	writeRecord(&buf, fcgiStderr, []byte("err"))
+	writeRecord(&buf, fcgiEndRequest, make([]byte, 8))
+
+	stdout, stderr, err := readResponseRecords(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "out" {
+		t.Fatalf("expected stdout %q, got %q", "out", stdout.String())
+	}
+	if stderr.String() != "err" {
+		t.Fatalf("expected stderr %q, got %q", "err", stderr.String())
+	}
+}
+
+func TestParseFastCGIResponseParsesStatusAndHeaders(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	stdout := bytes.NewBufferString("Status: 404 Not Found\r\nContent-Type: text/plain\r\n\r\nnope")
+
+	resp, err := parseFastCGIResponse(req, stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "text/plain" {
+		t.Fatalf("expected Content-Type text/plain, got %q", got)
+	}
+	body, _ := readAll(resp.Body)
+	if string(body) != "nope" {
+		t.Fatalf("expected body %q, got %q", "nope", body)
+	}
+}
+
+func TestParseFastCGIResponseDefaultsTo200WithoutAStatusHeader(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	stdout := bytes.NewBufferString("Content-Type: text/plain\r\n\r\nok")
+
+	resp, err := parseFastCGIResponse(req, stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected default status 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestFastCGIParamsIncludesRequestHeadersAndScriptFilename(t *testing.T) {
+	f := NewFastCGI("tcp", "127.0.0.1:0", "/var/www")
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/index.php?a=1", nil)
+	req.Header.Set("X-Custom", "value")
+
+	params := f.params(req)
+	if params["SCRIPT_FILENAME"] != "/var/www/index.php" {
+		t.Fatalf("expected SCRIPT_FILENAME to join Root and the request path, got %q", params["SCRIPT_FILENAME"])
+	}
+	if params["QUERY_STRING"] != "a=1" {
+		t.Fatalf("expected QUERY_STRING to be preserved, got %q", params["QUERY_STRING"])
+	}
+	if params["HTTP_X_CUSTOM"] != "value" {
+		t.Fatalf("expected request headers to be forwarded as HTTP_*, got %v", params)
+	}
+}
+
+func TestFastCGIParamsOmitsContentLengthWhenUnknown(t *testing.T) {
+	f := NewFastCGI("tcp", "127.0.0.1:0", "/var/www")
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/index.php", nil)
+	req.ContentLength = -1
+
+	if _, ok := f.params(req)["CONTENT_LENGTH"]; ok {
+		t.Fatal("expected CONTENT_LENGTH to be omitted when ContentLength is unknown (-1)")
+	}
+}
+
+// TestFastCGIRoundTrip drives FastCGI.RoundTrip against a minimal fake
+// FastCGI backend that just echoes a canned response, exercising the
+// record-writing and record-reading halves of the protocol together.
+func TestFastCGIRoundTrip(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// Drain the BEGIN_REQUEST, PARAMS, and STDIN records the client
+		// sends, stopping at the zero-length STDIN record that terminates
+		// the request.
+		br := bufio.NewReader(conn)
+		for {
+			var h fcgiHeader
+			if err := binary.Read(br, binary.BigEndian, &h); err != nil {
+				return
+			}
+			if _, err := io.CopyN(ioutil.Discard, br, int64(h.ContentLength)+int64(h.PaddingLength)); err != nil {
+				return
+			}
+			if h.Type == fcgiStdin && h.ContentLength == 0 {
+				break
+			}
+		}
+
+		var out bytes.Buffer
+		out.WriteString("Status: 200 OK\r\nContent-Type: text/plain\r\n\r\nhello from fastcgi")
+		writeRecord(conn, fcgiStdout, out.Bytes())
+		writeRecord(conn, fcgiEndRequest, make([]byte, 8))
+	}()
+
+	f := NewFastCGI("tcp", ln.Addr().String(), "/var/www")
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/index.php", nil)
+
+	resp, err := f.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := readAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), "hello from fastcgi") {
+		t.Fatalf("expected the backend's body to come through, got %q", body)
+	}
+}
+
+func readAll(r io.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := io.Copy(&buf, r)
+	return buf.Bytes(), err
+}
@@ -0,0 +1,26 @@
+// Package transport abstracts the "upstream carrier" a proxy speaks to once
+// it's decided where a request should go. The proxy package's original
+// behavior - dialing a raw TCP connection and either writing an HTTP
+// request to it or piping bytes for CONNECT - is one Transport
+// implementation (Direct). Others can speak an entirely different wire
+// protocol to reach the same kind of backend, e.g. FastCGI to front a
+// PHP/Python app server directly.
+package transport
+
+import (
+	"net"
+	"net/http"
+)
+
+// Transport reaches an upstream on behalf of the proxy. Implementations
+// must be safe for concurrent use.
+type Transport interface {
+	// RoundTrip performs a single plain-HTTP request/response against the
+	// upstream and returns the response, analogous to http.RoundTripper.
+	RoundTrip(req *http.Request) (*http.Response, error)
+
+	// Hijack takes over downstream, a CONNECT tunnel, and wires it to addr
+	// however is appropriate for this transport. It blocks until the
+	// tunnel is done and closes downstream before returning.
+	Hijack(downstream net.Conn, addr string) error
+}
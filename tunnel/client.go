@@ -0,0 +1,196 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	kcp "github.com/xtaci/kcp-go/v5"
+	"github.com/xtaci/smux"
+)
+
+// ProxyRequestHandler serves a single request that the rendezvous server
+// routed to this client. stream behaves like a normal downstream net.Conn
+// and is meant to be run through the same filter chain and proxy.copy
+// machinery used for directly-accepted connections.
+type ProxyRequestHandler func(target string, stream net.Conn)
+
+// ClientOpts configures a Client.
+type ClientOpts struct {
+	// RendezvousAddr is the address of the public rendezvous server to
+	// register with.
+	RendezvousAddr string
+
+	// TenantID identifies this client to the rendezvous server.
+	TenantID string
+
+	// Token authenticates this client to the rendezvous server.
+	Token string
+
+	// UseKCP selects KCP (over UDP) as the transport to the rendezvous
+	// server. If false, or if the KCP dial fails, a plain TCP connection is
+	// used instead.
+	UseKCP bool
+
+	// Handle is invoked for each ProxyRequest the rendezvous server routes
+	// to this client.
+	Handle ProxyRequestHandler
+}
+
+// Client maintains a persistent, multiplexed connection to a rendezvous
+// server and serves proxied requests the server routes to it.
+type Client struct {
+	opts    *ClientOpts
+	session *smux.Session
+	control *smux.Stream
+
+	// enc and dec wrap control for the lifetime of the connection. They
+	// must not be re-created mid-connection: gob.NewDecoder wraps control
+	// in its own bufio.Reader, and a second decoder built later would
+	// leave behind whatever that first bufio.Reader had already buffered
+	// off the wire, desyncing the gob stream.
+	enc *gob.Encoder
+	dec *gob.Decoder
+
+	// streams tracks in-flight tunneled streams by RequestID so that a
+	// CloseProxy from the rendezvous server can be applied to the right
+	// one.
+	streams sync.Map // requestID uint64 -> *smux.Stream
+}
+
+// Dial connects to the rendezvous server, authenticates, and returns a
+// Client ready to Serve.
+func Dial(opts *ClientOpts) (*Client, error) {
+	conn, err := dialRendezvous(opts.RendezvousAddr, opts.UseKCP)
+	if err != nil {
+		return nil, err
+	}
+	session, err := smux.Client(conn, smux.DefaultConfig())
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	control, err := session.OpenStream()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	c := &Client{
+		opts:    opts,
+		session: session,
+		control: control,
+		enc:     gob.NewEncoder(control),
+		dec:     gob.NewDecoder(control),
+	}
+	if err := c.authenticate(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func dialRendezvous(addr string, useKCP bool) (net.Conn, error) {
+	if useKCP {
+		conn, err := kcp.DialWithOptions(addr, nil, 10, 3)
+		if err == nil {
+			return conn, nil
+		}
+		log.Debugf("KCP dial to %v failed, falling back to TCP: %v", addr, err)
+	}
+	return net.DialTimeout("tcp", addr, 10*time.Second)
+}
+
+func (c *Client) authenticate() error {
+	if err := c.enc.Encode(MessageAuthRequest); err != nil {
+		return err
+	}
+	if err := c.enc.Encode(AuthRequest{TenantID: c.opts.TenantID, Token: c.opts.Token}); err != nil {
+		return err
+	}
+
+	var msgType MessageType
+	if err := c.dec.Decode(&msgType); err != nil {
+		return err
+	}
+	if msgType != MessageAuthResponse {
+		return fmt.Errorf("tunnel: expected AuthResponse, got message type %d", msgType)
+	}
+	var resp AuthResponse
+	if err := c.dec.Decode(&resp); err != nil {
+		return err
+	}
+	if !resp.OK {
+		return fmt.Errorf("tunnel: authentication rejected: %s", resp.Error)
+	}
+	return nil
+}
+
+// Serve reads control messages from the rendezvous server until the
+// connection is closed, opening a new smux stream and invoking
+// opts.Handle for each ProxyRequest it receives. It blocks until the
+// control stream errors out (typically because the connection was lost).
+func (c *Client) Serve() error {
+	for {
+		var msgType MessageType
+		if err := c.dec.Decode(&msgType); err != nil {
+			return err
+		}
+		switch msgType {
+		case MessageProxyRequest:
+			var req ProxyRequest
+			if err := c.dec.Decode(&req); err != nil {
+				return err
+			}
+			go c.serveProxyRequest(req)
+		case MessageCloseProxy:
+			var msg CloseProxy
+			if err := c.dec.Decode(&msg); err != nil {
+				return err
+			}
+			if v, ok := c.streams.Load(msg.RequestID); ok {
+				// This unblocks whatever opts.Handle call is reading from
+				// or writing to the stream, letting serveProxyRequest
+				// return.
+				v.(*smux.Stream).Close()
+			}
+		default:
+			log.Debugf("Ignoring unexpected control message type %d", msgType)
+		}
+	}
+}
+
+func (c *Client) serveProxyRequest(req ProxyRequest) {
+	stream, err := c.session.OpenStream()
+	if err != nil {
+		log.Errorf("Error opening stream for tunneled request %d: %v", req.RequestID, err)
+		return
+	}
+	defer stream.Close()
+
+	// Tag the stream with its RequestID so the rendezvous server - which
+	// may have several ProxyRequests in flight for this tenant at once -
+	// can match the stream it just accepted to the request that caused us
+	// to open it, rather than relying on Accept/Open arrival order (which
+	// isn't guaranteed to line up under concurrency).
+	var idBuf [8]byte
+	binary.BigEndian.PutUint64(idBuf[:], req.RequestID)
+	if _, err := stream.Write(idBuf[:]); err != nil {
+		log.Errorf("Error tagging stream for tunneled request %d: %v", req.RequestID, err)
+		return
+	}
+
+	c.streams.Store(req.RequestID, stream)
+	defer c.streams.Delete(req.RequestID)
+
+	c.opts.Handle(req.Target, stream)
+}
+
+// Close tears down the tunnel to the rendezvous server.
+func (c *Client) Close() error {
+	c.control.Close()
+	return c.session.Close()
+}
@@ -0,0 +1,5 @@
+package tunnel
+
+import "github.com/getlantern/golog"
+
+var log = golog.LoggerFor("tunnel")
@@ -0,0 +1,59 @@
+// Package tunnel lets a proxy instance behind NAT register with a public
+// rendezvous server over a single long-lived, multiplexed connection and
+// receive proxied CONNECT/HTTP requests over it, without needing to open
+// any inbound ports itself.
+//
+// A Client dials the Rendezvous over KCP (falling back to plain TCP) and
+// opens a smux session. A control stream carries the messages below; each
+// newly proxied request gets its own freshly-opened smux stream, which
+// callers treat exactly like a normal downstream net.Conn accepted off a
+// listener.
+//
+// On the public-facing side, Transport adapts a Rendezvous into a
+// transport.Transport: drop it into proxy.Opts.Transport (resolving
+// tenants by target hostname via a TenantResolver) and an ordinary
+// proxy.New(opts).Handle loop - accepting connections off whatever
+// listener the embedding application already runs - will route CONNECT
+// and plain HTTP requests to the matching tenant Client's stream instead
+// of dialing an origin directly.
+package tunnel
+
+// MessageType identifies the kind of control message that follows it on a
+// tunnel's control stream. Every control message is preceded by its
+// MessageType so the reader knows what to decode next.
+type MessageType uint8
+
+const (
+	MessageAuthRequest MessageType = iota + 1
+	MessageAuthResponse
+	MessageProxyRequest
+	MessageCloseProxy
+)
+
+// AuthRequest is sent by a Client immediately after opening the control
+// stream, identifying which tenant it's registering proxy capacity for.
+type AuthRequest struct {
+	TenantID string
+	Token    string
+}
+
+// AuthResponse acknowledges or rejects an AuthRequest.
+type AuthResponse struct {
+	OK    bool
+	Error string
+}
+
+// ProxyRequest tells the Client to serve a newly proxied request. The
+// Client responds by opening a new smux stream on its session; the
+// Rendezvous accepts that stream and hands it to the DialTenant call that
+// sent the request, which returns it as that request's upstream connection.
+type ProxyRequest struct {
+	RequestID uint64
+	Target    string
+}
+
+// CloseProxy tells the Client that RequestID's stream is done being routed
+// and any bookkeeping for it can be cleaned up.
+type CloseProxy struct {
+	RequestID uint64
+}
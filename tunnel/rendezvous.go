@@ -0,0 +1,290 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cmap "github.com/orcaman/concurrent-map"
+	kcp "github.com/xtaci/kcp-go/v5"
+	"github.com/xtaci/smux"
+)
+
+// streamCorrelationTimeout bounds how long DialTenant waits for the client
+// to open the stream for a request it just sent. If the client is wedged
+// or the tenant connection dies without the session noticing yet, we'd
+// otherwise leak a pending entry and block the caller forever.
+const streamCorrelationTimeout = 30 * time.Second
+
+// AuthFunc validates an AuthRequest, returning a non-nil error if the
+// tenant/token pair isn't recognized.
+type AuthFunc func(req AuthRequest) error
+
+// RendezvousOpts configures a Rendezvous server.
+type RendezvousOpts struct {
+	// Addr is the local address to listen on.
+	Addr string
+
+	// UseKCP listens for KCP (UDP) connections rather than plain TCP.
+	UseKCP bool
+
+	// Authenticate validates incoming AuthRequests. If nil, all requests
+	// are accepted.
+	Authenticate AuthFunc
+}
+
+// tenant tracks a single authenticated client's session and control
+// stream, so inbound requests can be routed to it by tenant ID.
+type tenant struct {
+	session *smux.Session
+	control *smux.Stream
+	nextID  uint64
+
+	// encMu guards control: DialTenant (ProxyRequest) and the stream
+	// cleanup path (CloseProxy) both write to it and may run concurrently
+	// for the same tenant, and gob.Encoder isn't safe for concurrent use.
+	encMu sync.Mutex
+	enc   *gob.Encoder
+
+	// pending holds a channel per in-flight ProxyRequest, keyed by
+	// RequestID, so acceptStreams can hand each newly accepted stream back
+	// to the DialTenant call that requested it instead of relying on
+	// Accept/Open order lining up under concurrency.
+	pending sync.Map // requestID uint64 -> chan net.Conn
+}
+
+func newTenant(session *smux.Session, control *smux.Stream) *tenant {
+	t := &tenant{
+		session: session,
+		control: control,
+		enc:     gob.NewEncoder(control),
+	}
+	go t.acceptStreams()
+	return t
+}
+
+// acceptStreams reads every stream the client opens on this tenant's
+// session, expecting each to start with the RequestID of the ProxyRequest
+// that caused the client to open it, and routes it to the matching
+// DialTenant call. It returns once the session is closed.
+func (t *tenant) acceptStreams() {
+	for {
+		stream, err := t.session.AcceptStream()
+		if err != nil {
+			return
+		}
+		go t.dispatchStream(stream)
+	}
+}
+
+func (t *tenant) dispatchStream(stream *smux.Stream) {
+	var idBuf [8]byte
+	if _, err := io.ReadFull(stream, idBuf[:]); err != nil {
+		log.Errorf("Error reading correlation id from tunneled stream: %v", err)
+		stream.Close()
+		return
+	}
+	requestID := binary.BigEndian.Uint64(idBuf[:])
+
+	v, ok := t.pending.LoadAndDelete(requestID)
+	if !ok {
+		log.Errorf("Received stream for unknown or already-abandoned request %d", requestID)
+		stream.Close()
+		return
+	}
+	v.(chan net.Conn) <- stream
+}
+
+func (t *tenant) sendProxyRequest(req ProxyRequest) error {
+	t.encMu.Lock()
+	defer t.encMu.Unlock()
+	if err := t.enc.Encode(MessageProxyRequest); err != nil {
+		return err
+	}
+	return t.enc.Encode(req)
+}
+
+func (t *tenant) sendCloseProxy(requestID uint64) {
+	t.encMu.Lock()
+	defer t.encMu.Unlock()
+	if err := t.enc.Encode(MessageCloseProxy); err != nil {
+		log.Errorf("Error sending CloseProxy for request %d: %v", requestID, err)
+		return
+	}
+	if err := t.enc.Encode(CloseProxy{RequestID: requestID}); err != nil {
+		log.Errorf("Error sending CloseProxy for request %d: %v", requestID, err)
+	}
+}
+
+// Rendezvous accepts registrations from Clients behind NAT and routes
+// inbound CONNECT/HTTP requests to them by tenant ID.
+type Rendezvous struct {
+	opts    *RendezvousOpts
+	tenants cmap.ConcurrentMap // tenantID -> *tenant
+}
+
+// NewRendezvous creates a Rendezvous server per opts. Call ListenAndServe
+// to start accepting client registrations.
+func NewRendezvous(opts *RendezvousOpts) *Rendezvous {
+	return &Rendezvous{opts: opts, tenants: cmap.New()}
+}
+
+// ListenAndServe accepts client registrations until the listener errors
+// out (typically because it was closed).
+func (r *Rendezvous) ListenAndServe() error {
+	ln, err := r.listen()
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go r.handleClient(conn)
+	}
+}
+
+func (r *Rendezvous) listen() (net.Listener, error) {
+	if r.opts.UseKCP {
+		return kcp.ListenWithOptions(r.opts.Addr, nil, 10, 3)
+	}
+	return net.Listen("tcp", r.opts.Addr)
+}
+
+func (r *Rendezvous) handleClient(conn net.Conn) {
+	session, err := smux.Server(conn, smux.DefaultConfig())
+	if err != nil {
+		log.Errorf("Error establishing smux session: %v", err)
+		conn.Close()
+		return
+	}
+
+	control, err := session.AcceptStream()
+	if err != nil {
+		log.Errorf("Error accepting control stream: %v", err)
+		session.Close()
+		return
+	}
+
+	tenantID, err := r.authenticate(control)
+	if err != nil {
+		log.Errorf("Rejecting tunnel client: %v", err)
+		session.Close()
+		return
+	}
+
+	t := newTenant(session, control)
+	r.tenants.Set(tenantID, t)
+	defer func() {
+		r.tenants.Remove(tenantID)
+		session.Close()
+	}()
+
+	// Block until the client tears down its control stream (e.g. because
+	// it lost connectivity to us), which is our signal to stop routing
+	// requests to this tenant.
+	io.Copy(ioutil.Discard, control)
+}
+
+func (r *Rendezvous) authenticate(control *smux.Stream) (string, error) {
+	dec := gob.NewDecoder(control)
+	enc := gob.NewEncoder(control)
+
+	var msgType MessageType
+	if err := dec.Decode(&msgType); err != nil {
+		return "", err
+	}
+	if msgType != MessageAuthRequest {
+		return "", fmt.Errorf("expected AuthRequest, got message type %d", msgType)
+	}
+	var req AuthRequest
+	if err := dec.Decode(&req); err != nil {
+		return "", err
+	}
+
+	var authErr error
+	if r.opts.Authenticate != nil {
+		authErr = r.opts.Authenticate(req)
+	}
+
+	resp := AuthResponse{OK: authErr == nil}
+	if authErr != nil {
+		resp.Error = authErr.Error()
+	}
+	if err := enc.Encode(MessageAuthResponse); err != nil {
+		return "", err
+	}
+	if err := enc.Encode(resp); err != nil {
+		return "", err
+	}
+	if authErr != nil {
+		return "", authErr
+	}
+	return req.TenantID, nil
+}
+
+// DialTenant routes a CONNECT/HTTP request for target to the tenant
+// registered as tenantID (as resolved by the caller from the request's
+// hostname/SNI), opening a fresh stream on that tenant's session and
+// returning it as a net.Conn the caller can treat like any other upstream
+// connection. Closing the returned conn notifies the tenant via
+// CloseProxy so it can stop serving the request if it's still in
+// progress.
+func (r *Rendezvous) DialTenant(tenantID, target string) (net.Conn, error) {
+	v, ok := r.tenants.Get(tenantID)
+	if !ok {
+		return nil, fmt.Errorf("tunnel: no tenant registered for %q", tenantID)
+	}
+	t := v.(*tenant)
+
+	requestID := atomic.AddUint64(&t.nextID, 1)
+	waiter := make(chan net.Conn, 1)
+	t.pending.Store(requestID, waiter)
+
+	if err := t.sendProxyRequest(ProxyRequest{RequestID: requestID, Target: target}); err != nil {
+		t.pending.Delete(requestID)
+		return nil, err
+	}
+
+	select {
+	case stream := <-waiter:
+		return &closeNotifyingConn{Conn: stream, onClose: func() { t.sendCloseProxy(requestID) }}, nil
+	case <-time.After(streamCorrelationTimeout):
+		if _, stillPending := t.pending.LoadAndDelete(requestID); !stillPending {
+			// dispatchStream already claimed this request concurrently with
+			// our timeout firing and is (or just did) hand the stream off
+			// on waiter; wait briefly for it so we don't leak the stream it
+			// opened for a request we're about to report as failed.
+			select {
+			case stream := <-waiter:
+				stream.Close()
+			case <-time.After(time.Second):
+			}
+		}
+		return nil, fmt.Errorf("tunnel: timed out waiting for tenant %q to open a stream for request %d", tenantID, requestID)
+	}
+}
+
+// closeNotifyingConn wraps a tunneled stream so that closing it - by
+// either side of the proxy - tells the tunnel client the request is done,
+// completing the other half of the CloseProxy handshake described in
+// messages.go.
+type closeNotifyingConn struct {
+	net.Conn
+	once    sync.Once
+	onClose func()
+}
+
+func (c *closeNotifyingConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.onClose)
+	return err
+}
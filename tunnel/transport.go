@@ -0,0 +1,114 @@
+package tunnel
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/getlantern/netx"
+	"github.com/getlantern/proxy/transport"
+)
+
+// TenantResolver maps a request's target host (the host of the CONNECT
+// address, or of a plain request's URL) to the tenant ID registered for it
+// with a Rendezvous server. Transport does not read the TLS SNI of tunneled
+// traffic. Implementations are expected to be cheap and safe
+// for concurrent use, since they're called on every request.
+type TenantResolver func(host string) (tenantID string, ok bool)
+
+// StaticTenantResolver returns a TenantResolver backed by a fixed
+// host-to-tenant mapping, for the common case of a small, rarely-changing
+// set of tenant hostnames.
+func StaticTenantResolver(hostsToTenants map[string]string) TenantResolver {
+	return func(host string) (string, bool) {
+		tenantID, ok := hostsToTenants[host]
+		return tenantID, ok
+	}
+}
+
+// Transport is a transport.Transport that routes requests to tenant
+// Clients registered with a Rendezvous server, resolving which tenant
+// should serve a given request via Resolve. It lets a Rendezvous server be
+// dropped into an existing proxy.Opts.Transport alongside (or instead of)
+// transport.Direct and transport.FastCGI, so that CONNECT/HTTP requests
+// accepted by a public-facing proxy actually get tunneled to the
+// appropriate NAT'd tenant instead of the Rendezvous/Client machinery
+// sitting unused.
+type Transport struct {
+	rendezvous *Rendezvous
+	resolve    TenantResolver
+}
+
+// NewTransport returns a Transport that dials tenants registered with
+// rendezvous, picking which tenant serves a request via resolve.
+func NewTransport(rendezvous *Rendezvous, resolve TenantResolver) *Transport {
+	return &Transport{rendezvous: rendezvous, resolve: resolve}
+}
+
+var _ transport.Transport = (*Transport)(nil)
+
+// RoundTrip resolves req's host to a tenant, dials it via
+// Rendezvous.DialTenant, and performs req against it.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	conn, err := t.dial(req.URL.Host, req.URL.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body = &tenantConnClosingBody{ReadCloser: resp.Body, conn: conn}
+	return resp, nil
+}
+
+// Hijack resolves addr's host to a tenant, dials it via
+// Rendezvous.DialTenant, and pipes bytes between it and downstream until
+// either side closes.
+func (t *Transport) Hijack(downstream net.Conn, addr string) error {
+	defer downstream.Close()
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	upstream, err := t.dial(addr, host)
+	if err != nil {
+		return err
+	}
+	defer upstream.Close()
+
+	writeErr, readErr := netx.BidiCopy(upstream, downstream, make([]byte, 32*1024), make([]byte, 32*1024))
+	if readErr != nil && readErr != io.EOF {
+		return readErr
+	}
+	return writeErr
+}
+
+func (t *Transport) dial(target, host string) (net.Conn, error) {
+	tenantID, ok := t.resolve(host)
+	if !ok {
+		return nil, fmt.Errorf("tunnel: no tenant registered for host %q", host)
+	}
+	return t.rendezvous.DialTenant(tenantID, target)
+}
+
+type tenantConnClosingBody struct {
+	io.ReadCloser
+	conn net.Conn
+}
+
+func (b *tenantConnClosingBody) Close() error {
+	err := b.ReadCloser.Close()
+	if closeErr := b.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package tunnel
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTransportHijackRoutesToTheResolvedTenant(t *testing.T) {
+	r, addr := startTestRendezvous(t)
+
+	handle := func(target string, stream net.Conn) {
+		io.Copy(stream, bytes.NewReader([]byte("hello from "+target)))
+		stream.Close()
+	}
+	client := dialTestClient(t, addr, "tenant-transport", handle)
+	defer client.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	tr := NewTransport(r, StaticTenantResolver(map[string]string{
+		"origin.example.com": "tenant-transport",
+	}))
+
+	downstream, hijacked := net.Pipe()
+	errc := make(chan error, 1)
+	go func() { errc <- tr.Hijack(hijacked, "origin.example.com:443") }()
+
+	got, err := io.ReadAll(downstream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "hello from origin.example.com:443"; string(got) != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("expected Hijack to return cleanly, got %v", err)
+	}
+}
+
+func TestTransportHijackFailsForAnUnresolvedHost(t *testing.T) {
+	r, _ := startTestRendezvous(t)
+	tr := NewTransport(r, StaticTenantResolver(nil))
+
+	downstream, hijacked := net.Pipe()
+	defer downstream.Close()
+
+	if err := tr.Hijack(hijacked, "unknown.example.com:443"); err == nil {
+		t.Fatal("expected Hijack to fail when the host doesn't resolve to a tenant")
+	}
+}
+
+func TestTransportRoundTripRoutesToTheResolvedTenant(t *testing.T) {
+	r, addr := startTestRendezvous(t)
+
+	handle := func(target string, stream net.Conn) {
+		stream.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello"))
+		stream.Close()
+	}
+	client := dialTestClient(t, addr, "tenant-roundtrip", handle)
+	defer client.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	tr := NewTransport(r, StaticTenantResolver(map[string]string{
+		"origin.example.com": "tenant-roundtrip",
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "http://origin.example.com/", nil)
+	req.URL.Host = "origin.example.com:443"
+
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "hello" {
+		t.Fatalf("expected body %q, got %q", "hello", body)
+	}
+}
@@ -0,0 +1,119 @@
+package tunnel
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func startTestRendezvous(t *testing.T) (*Rendezvous, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	r := NewRendezvous(&RendezvousOpts{Addr: addr})
+	go r.ListenAndServe()
+	// Give the listener a moment to come up before clients start dialing.
+	time.Sleep(50 * time.Millisecond)
+	return r, addr
+}
+
+func dialTestClient(t *testing.T, addr, tenantID string, handle ProxyRequestHandler) *Client {
+	c, err := Dial(&ClientOpts{
+		RendezvousAddr: addr,
+		TenantID:       tenantID,
+		Handle:         handle,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go c.Serve()
+	return c
+}
+
+// TestDialTenantRoutesConcurrentRequestsToTheRightStream exercises the
+// RequestID correlation between DialTenant and the client's
+// serveProxyRequest: several requests are in flight on the same tenant at
+// once, and each must see its own target, not some other request's.
+func TestDialTenantRoutesConcurrentRequestsToTheRightStream(t *testing.T) {
+	r, addr := startTestRendezvous(t)
+
+	handle := func(target string, stream net.Conn) {
+		io.Copy(stream, bytes.NewReader([]byte(target)))
+		stream.Close()
+	}
+	client := dialTestClient(t, addr, "tenant-1", handle)
+	defer client.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		target := fmt.Sprintf("host-%d.example.com:443", i)
+		go func(target string) {
+			defer wg.Done()
+			conn, err := r.DialTenant("tenant-1", target)
+			if err != nil {
+				t.Errorf("DialTenant(%q): %v", target, err)
+				return
+			}
+			defer conn.Close()
+			got, err := io.ReadAll(conn)
+			if err != nil {
+				t.Errorf("reading tunneled response for %q: %v", target, err)
+				return
+			}
+			if string(got) != target {
+				t.Errorf("expected tunneled stream for %q to see its own target, got %q", target, got)
+			}
+		}(target)
+	}
+	wg.Wait()
+}
+
+// TestDialTenantUnknownTenant verifies that routing to a tenant ID with no
+// registered client fails fast instead of blocking.
+func TestDialTenantUnknownTenant(t *testing.T) {
+	r, _ := startTestRendezvous(t)
+	if _, err := r.DialTenant("no-such-tenant", "example.com:443"); err == nil {
+		t.Fatal("expected an error routing to an unregistered tenant")
+	}
+}
+
+// TestCloseProxyUnblocksClientHandle verifies that closing the conn
+// returned by DialTenant causes the rendezvous to send CloseProxy, which
+// the client applies by closing the corresponding stream - unblocking
+// whatever opts.Handle call is reading from it.
+func TestCloseProxyUnblocksClientHandle(t *testing.T) {
+	r, addr := startTestRendezvous(t)
+
+	handleReturned := make(chan struct{})
+	handle := func(target string, stream net.Conn) {
+		defer close(handleReturned)
+		// Blocks until the stream is closed out from under it by a
+		// CloseProxy, since nothing ever writes to the other end.
+		io.Copy(io.Discard, stream)
+	}
+	client := dialTestClient(t, addr, "tenant-2", handle)
+	defer client.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	conn, err := r.DialTenant("tenant-2", "example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	select {
+	case <-handleReturned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected CloseProxy to unblock the client's Handle call")
+	}
+}